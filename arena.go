@@ -0,0 +1,381 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"reflect"
+	"unsafe"
+)
+
+// Buddy allocator living entirely inside a segment, so every process attached to the
+// segment allocates from and frees to the same heap:
+//
+//	| segment header | arenaMeta | heap ... |
+//
+// The heap is made of blocks of 2^order bytes, each aligned to its size relative to the heap
+// start and beginning with an arenaBlock. Free blocks are kept in one doubly linked list per
+// order. Allocating splits a larger block in halves until the request fits, freeing merges a
+// block with its buddy, the other half of the block it was split from, while that is free too.
+//
+// All links are offsets from the segment start rather than addresses, the segment is attached
+// at a different address in every process. Offset 0 is the segment header and never a block,
+// so it serves as nil.
+
+const (
+	arenaVersion = 1
+
+	arenaMinOrder = 5 // 32 byte blocks, room for a free block header
+	arenaMaxOrder = 62
+	arenaOverhead = 16 // tag, order and size in front of every allocation
+
+	arenaTagFree = 0x45455246 // "FREE"
+	arenaTagUsed = 0x44455355 // "USED"
+)
+
+var (
+	ErrArenaFull   = errors.New("[error] arena has no free block large enough")
+	ErrArenaOffset = errors.New("[error] offset is not an allocation of the arena")
+)
+
+// arenaMeta is the allocator state at the start of the segment, after the segment header
+type arenaMeta struct {
+	MaxOrder uint32
+	_        uint32
+	Heap     uint64 // segment offset of the first block
+	Size     uint64 // heap bytes
+	Used     uint64 // bytes in allocated blocks, headers included
+	Allocs   uint64
+	Free     [arenaMaxOrder + 1]uint64 // first free block of each order
+}
+
+// arenaBlock is the header of every block. An allocated block only uses tag, order and size,
+// prev overlaps its payload
+type arenaBlock struct {
+	tag   uint32
+	order uint32
+	size  uint64 // requested bytes of an allocated block, next free block of a free one
+	prev  uint64 // previous free block
+}
+
+// Arena ... Allocator for variable sized objects inside a shared memory segment.
+// The metadata lives in the segment and is protected by a cross-process Lock, which every
+// process using the arena must pass in. If a process dies inside an allocator call the
+// metadata may be inconsistent, SemLock releases the lock on exit but cannot repair it.
+// The arena does not own the segment or the lock
+type Arena struct {
+	seg  *Segment
+	lock Lock
+	meta *arenaMeta
+}
+
+// CreateArena ... Sets up an empty arena covering all of seg, overwriting its contents
+func CreateArena(seg *Segment, lock Lock) (*Arena, error) {
+	// check the size before the header makes the segment look like an arena
+	heap := alignUp(SegmentHeaderSize+int(unsafe.Sizeof(arenaMeta{})), cacheLineSize)
+	size := (int(seg.Size()) - heap) &^ (1<<arenaMinOrder - 1)
+	if size < 1<<arenaMinOrder {
+		return nil, fmt.Errorf("[error] segment of %d bytes too small for an arena", seg.Size())
+	}
+	meta, err := CreateShared[arenaMeta](seg, arenaVersion)
+	if err != nil {
+		return nil, err
+	}
+	a := &Arena{seg: seg, lock: lock, meta: meta.Get()}
+
+	lock.Lock()
+	defer lock.Unlock()
+	*a.meta = arenaMeta{
+		MaxOrder: uint32(min(bits.Len64(uint64(size))-1, arenaMaxOrder)),
+		Heap:     uint64(heap),
+		Size:     uint64(size),
+	}
+	mem := seg.Bytes()
+	// cover the heap with the largest blocks that are aligned to their size
+	for rel := uint64(0); a.meta.Size-rel >= 1<<arenaMinOrder; {
+		order := a.meta.MaxOrder
+		for order > arenaMinOrder && (rel&(1<<order-1) != 0 || rel+1<<order > a.meta.Size) {
+			order--
+		}
+		a.push(mem, a.meta.Heap+rel, order)
+		rel += 1 << order
+	}
+	return a, nil
+}
+
+// OpenArena ... Opens an arena set up by CreateArena in another process or attachment
+func OpenArena(seg *Segment, lock Lock) (*Arena, error) {
+	meta, err := OpenShared[arenaMeta](seg, arenaVersion)
+	if err != nil {
+		return nil, err
+	}
+	m := meta.Get()
+	if m.MaxOrder < arenaMinOrder || m.MaxOrder > arenaMaxOrder || m.Heap < uint64(SegmentHeaderSize) ||
+		m.Size > uint64(seg.Size()) || m.Heap > uint64(seg.Size())-m.Size {
+		return nil, fmt.Errorf("%w: arena of %d bytes at %d", ErrSegmentCapacity, m.Size, m.Heap)
+	}
+	return &Arena{seg: seg, lock: lock, meta: m}, nil
+}
+
+// Segment ... Returns the segment holding the arena
+func (a *Arena) Segment() *Segment {
+	return a.seg
+}
+
+// Cap ... Returns the heap size in bytes
+func (a *Arena) Cap() int {
+	return int(a.meta.Size)
+}
+
+// Used ... Returns the bytes taken by allocated blocks, including headers and rounding
+func (a *Arena) Used() int {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return int(a.meta.Used)
+}
+
+// Allocs ... Returns the number of live allocations
+func (a *Arena) Allocs() int {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return int(a.meta.Allocs)
+}
+
+// Alloc ... Allocates size bytes and returns their offset in the segment. The memory is
+// 16 byte aligned and zeroed
+func (a *Arena) Alloc(size int) (uint64, error) {
+	mem := a.seg.Bytes()
+	if mem == nil {
+		return 0, ErrSegmentClosed
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("[error] invalid allocation size %d", size)
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.alloc(mem, size)
+}
+
+// Free ... Returns an allocation to the arena. Freeing offset 0 does nothing
+func (a *Arena) Free(off uint64) error {
+	if off == 0 {
+		return nil
+	}
+	mem := a.seg.Bytes()
+	if mem == nil {
+		return ErrSegmentClosed
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	blk, err := a.used(mem, off)
+	if err != nil {
+		return err
+	}
+	a.free(mem, blk)
+	return nil
+}
+
+// Realloc ... Resizes an allocation and returns its possibly new offset. The contents are kept
+// up to the smaller of both sizes, grown memory is zeroed. Offset 0 allocates, size 0 frees and
+// returns 0. On failure the old allocation is unchanged
+func (a *Arena) Realloc(off uint64, size int) (uint64, error) {
+	if off == 0 {
+		return a.Alloc(size)
+	}
+	if size == 0 {
+		return 0, a.Free(off)
+	}
+	mem := a.seg.Bytes()
+	if mem == nil {
+		return 0, ErrSegmentClosed
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("[error] invalid allocation size %d", size)
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	blk, err := a.used(mem, off)
+	if err != nil {
+		return 0, err
+	}
+	b := a.block(mem, blk)
+	if uint64(size)+arenaOverhead <= 1<<b.order {
+		if uint64(size) > b.size {
+			clear(mem[off+b.size : off+uint64(size)])
+		}
+		b.size = uint64(size)
+		return off, nil
+	}
+	moved, err := a.alloc(mem, size)
+	if err != nil {
+		return 0, err
+	}
+	copy(mem[moved:moved+b.size], mem[off:off+b.size])
+	a.free(mem, blk)
+	return moved, nil
+}
+
+// Bytes ... Returns the memory of an allocation, nil if off is not one
+func (a *Arena) Bytes(off uint64) []byte {
+	mem := a.seg.Bytes()
+	if mem == nil || off == 0 {
+		return nil
+	}
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	blk, err := a.used(mem, off)
+	if err != nil {
+		return nil
+	}
+	size := a.block(mem, blk).size
+	return mem[off : off+size : off+size]
+}
+
+// alloc takes a block of the smallest sufficient order, splitting larger ones as needed
+func (a *Arena) alloc(mem []byte, size int) (uint64, error) {
+	order := uint32(max(bits.Len64(uint64(size)+arenaOverhead-1), arenaMinOrder))
+	if order > a.meta.MaxOrder {
+		return 0, fmt.Errorf("%w: %d bytes requested", ErrArenaFull, size)
+	}
+	k := order
+	for k <= a.meta.MaxOrder && a.meta.Free[k] == 0 {
+		k++
+	}
+	if k > a.meta.MaxOrder {
+		return 0, fmt.Errorf("%w: %d bytes requested, %d of %d in use", ErrArenaFull, size, a.meta.Used, a.meta.Size)
+	}
+	blk := a.meta.Free[k]
+	a.unlink(mem, blk)
+	for k > order {
+		k--
+		a.push(mem, blk+1<<k, k)
+	}
+	b := a.block(mem, blk)
+	*b = arenaBlock{tag: arenaTagUsed, order: order, size: uint64(size)}
+	a.meta.Used += 1 << order
+	a.meta.Allocs++
+	off := blk + arenaOverhead
+	clear(mem[off : off+uint64(size)])
+	return off, nil
+}
+
+// free merges blk with its free buddies and puts the result on a free list
+func (a *Arena) free(mem []byte, blk uint64) {
+	b := a.block(mem, blk)
+	order := b.order
+	b.tag = 0 // a stale header inside a merged block must not pass for an allocation
+	a.meta.Used -= 1 << order
+	a.meta.Allocs--
+	for order < a.meta.MaxOrder {
+		rel := (blk - a.meta.Heap) ^ 1<<order
+		if rel+1<<order > a.meta.Size {
+			break
+		}
+		buddy := a.meta.Heap + rel
+		if b := a.block(mem, buddy); b.tag != arenaTagFree || b.order != order {
+			break
+		}
+		a.unlink(mem, buddy)
+		blk = min(blk, buddy)
+		order++
+	}
+	a.push(mem, blk, order)
+}
+
+// used returns the block of the allocation at off
+func (a *Arena) used(mem []byte, off uint64) (uint64, error) {
+	blk := off - arenaOverhead
+	if off < a.meta.Heap+arenaOverhead || blk-a.meta.Heap >= a.meta.Size ||
+		(blk-a.meta.Heap)&(1<<arenaMinOrder-1) != 0 || a.block(mem, blk).tag != arenaTagUsed {
+		return 0, fmt.Errorf("%w: %d", ErrArenaOffset, off)
+	}
+	return blk, nil
+}
+
+func (a *Arena) block(mem []byte, blk uint64) *arenaBlock {
+	return (*arenaBlock)(unsafe.Pointer(&mem[blk]))
+}
+
+func (a *Arena) push(mem []byte, blk uint64, order uint32) {
+	next := a.meta.Free[order]
+	*a.block(mem, blk) = arenaBlock{tag: arenaTagFree, order: order, size: next}
+	if next != 0 {
+		a.block(mem, next).prev = blk
+	}
+	a.meta.Free[order] = blk
+}
+
+func (a *Arena) unlink(mem []byte, blk uint64) {
+	b := a.block(mem, blk)
+	if b.prev != 0 {
+		a.block(mem, b.prev).size = b.size
+	} else {
+		a.meta.Free[b.order] = b.size
+	}
+	if b.size != 0 {
+		a.block(mem, b.size).prev = b.prev
+	}
+	b.tag = 0
+}
+
+func alignUp(n, align int) int {
+	return (n + align - 1) &^ (align - 1)
+}
+
+// Offset ... Process independent reference to a T allocated in an Arena, the position
+// of the value in the segment. Unlike a pointer it can be stored in shared memory, including
+// inside other arena allocations and Shared values, and resolved by every process.
+// The zero Offset is nil
+type Offset[T any] uint64
+
+// ArenaNew ... Allocates a zeroed T in the arena. T must be free of pointers like for Shared
+func ArenaNew[T any](a *Arena) (Offset[T], error) {
+	return ArenaNewSlice[T](a, 1)
+}
+
+// ArenaNewSlice ... Allocates n zeroed consecutive values of T in the arena
+func ArenaNewSlice[T any](a *Arena, n int) (Offset[T], error) {
+	typ := reflect.TypeFor[T]()
+	if err := checkSharedType(typ, typ.String()); err != nil {
+		return 0, err
+	}
+	if align := typ.Align(); align > arenaOverhead {
+		return 0, fmt.Errorf("[error] %s needs %d byte alignment, the arena provides %d", typ, align, arenaOverhead)
+	}
+	if n < 0 || typ.Size() != 0 && uint64(n) > a.meta.Size/uint64(typ.Size()) {
+		return 0, fmt.Errorf("%w: %d values of %s", ErrArenaFull, n, typ)
+	}
+	off, err := a.Alloc(n * int(typ.Size()))
+	return Offset[T](off), err
+}
+
+// IsNil ... Reports whether o refers to nothing
+func (o Offset[T]) IsNil() bool {
+	return o == 0
+}
+
+// Get ... Resolves o against the attach address of the arena's segment in this process.
+// Returns nil for the nil Offset or one that does not fit in the segment
+func (o Offset[T]) Get(a *Arena) *T {
+	s := o.Slice(a, 1)
+	if s == nil {
+		return nil
+	}
+	return &s[0]
+}
+
+// Slice ... Resolves o as n consecutive values, as allocated by ArenaNewSlice
+func (o Offset[T]) Slice(a *Arena, n int) []T {
+	mem := a.seg.Bytes()
+	size := uint64(unsafe.Sizeof(*new(T)))
+	if o == 0 || mem == nil || n <= 0 || uint64(o) >= uint64(len(mem)) ||
+		size != 0 && uint64(n) > (uint64(len(mem))-uint64(o))/size {
+		return nil
+	}
+	return unsafe.Slice((*T)(unsafe.Pointer(&mem[o])), n)
+}
+
+// Free ... Returns the value to the arena
+func (o Offset[T]) Free(a *Arena) error {
+	return a.Free(uint64(o))
+}
@@ -0,0 +1,237 @@
+package ipc
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+type arenaNode struct {
+	Value uint64
+	Next  Offset[arenaNode]
+}
+
+func testArena(t *testing.T, size uint64) (*ShmInfo, int, *Arena) {
+	s, id := testSegment(t, size)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { seg.Close() })
+	lock, err := NewLock("test/a", SemLockMode, 21)
+	require.NoError(t, err)
+	t.Cleanup(lock.Close)
+	a, err := CreateArena(seg, lock)
+	require.NoError(t, err)
+	return s, id, a
+}
+
+func TestArena_AllocFree(t *testing.T) {
+	_, _, a := testArena(t, 64<<10)
+	require.Zero(t, a.Used())
+
+	sizes := []int{0, 1, 16, 17, 100, 1000, 5000}
+	offs := make([]uint64, len(sizes))
+	for i, size := range sizes {
+		off, err := a.Alloc(size)
+		require.NoError(t, err)
+		require.Zero(t, off%16)
+		require.Len(t, a.Bytes(off), size)
+		for j := range a.Bytes(off) {
+			a.Bytes(off)[j] = byte(i + 1)
+		}
+		offs[i] = off
+	}
+	require.Equal(t, len(sizes), a.Allocs())
+	// no allocation overwrote another
+	for i, off := range offs {
+		for _, b := range a.Bytes(off) {
+			require.EqualValues(t, i+1, b)
+		}
+	}
+
+	for _, off := range offs {
+		require.NoError(t, a.Free(off))
+	}
+	require.Zero(t, a.Used())
+	require.Zero(t, a.Allocs())
+	require.ErrorIs(t, a.Free(offs[3]), ErrArenaOffset)
+	require.ErrorIs(t, a.Free(offs[3]+8), ErrArenaOffset)
+	require.ErrorIs(t, a.Free(1<<40), ErrArenaOffset)
+	require.NoError(t, a.Free(0))
+
+	// freed blocks merged back into the largest one
+	off, err := a.Alloc(a.Cap()/2 - 16)
+	require.NoError(t, err)
+	require.NoError(t, a.Free(off))
+}
+
+func TestArena_TooSmall(t *testing.T) {
+	// room for the metadata, but not for a heap after it
+	s, id := testSegment(t, 700)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	_, err = CreateArena(seg, nil)
+	require.ErrorContains(t, err, "too small")
+	// nothing was written that looks like an arena
+	_, err = OpenArena(seg, nil)
+	require.ErrorIs(t, err, ErrSegmentMagic)
+}
+
+func TestArena_Full(t *testing.T) {
+	_, _, a := testArena(t, 8<<10)
+	_, err := a.Alloc(a.Cap())
+	require.ErrorIs(t, err, ErrArenaFull)
+
+	var offs []uint64
+	for {
+		off, err := a.Alloc(100)
+		if err != nil {
+			require.ErrorIs(t, err, ErrArenaFull)
+			break
+		}
+		offs = append(offs, off)
+	}
+	require.Len(t, offs, a.Cap()/128)
+	require.NoError(t, a.Free(offs[0]))
+	_, err = a.Alloc(100)
+	require.NoError(t, err)
+}
+
+func TestArena_Realloc(t *testing.T) {
+	_, _, a := testArena(t, 64<<10)
+	off, err := a.Realloc(0, 10)
+	require.NoError(t, err)
+	copy(a.Bytes(off), "0123456789")
+
+	// fits the 32 byte block
+	same, err := a.Realloc(off, 16)
+	require.NoError(t, err)
+	require.Equal(t, off, same)
+	require.Equal(t, "0123456789\x00\x00\x00\x00\x00\x00", string(a.Bytes(off)))
+
+	moved, err := a.Realloc(off, 3000)
+	require.NoError(t, err)
+	require.NotEqual(t, off, moved)
+	require.Equal(t, "0123456789", string(a.Bytes(moved)[:10]))
+	require.Nil(t, a.Bytes(off))
+	require.Equal(t, 1, a.Allocs())
+
+	_, err = a.Realloc(moved, a.Cap())
+	require.ErrorIs(t, err, ErrArenaFull)
+	require.Len(t, a.Bytes(moved), 3000)
+
+	gone, err := a.Realloc(moved, 0)
+	require.NoError(t, err)
+	require.Zero(t, gone)
+	require.Zero(t, a.Used())
+}
+
+func TestArena_Offset(t *testing.T) {
+	s, id, a := testArena(t, 64<<10)
+
+	// a linked list built from offsets
+	var head Offset[arenaNode]
+	for i := uint64(1); i <= 5; i++ {
+		n, err := ArenaNew[arenaNode](a)
+		require.NoError(t, err)
+		n.Get(a).Value = i
+		n.Get(a).Next = head
+		head = n
+	}
+	// the root is kept in the arena too
+	root, err := ArenaNew[Offset[arenaNode]](a)
+	require.NoError(t, err)
+	*root.Get(a) = head
+	vals, err := ArenaNewSlice[uint32](a, 3)
+	require.NoError(t, err)
+	copy(vals.Slice(a, 3), []uint32{7, 8, 9})
+
+	// another attachment resolves the same offsets at another address
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+	other, err := OpenArena(seg, a.lock)
+	require.NoError(t, err)
+	require.NotSame(t, head.Get(a), head.Get(other))
+
+	var got []uint64
+	require.Equal(t, []uint32{7, 8, 9}, vals.Slice(other, 3))
+	for n := *root.Get(other); !n.IsNil(); n = n.Get(other).Next {
+		got = append(got, n.Get(other).Value)
+	}
+	require.Equal(t, []uint64{5, 4, 3, 2, 1}, got)
+
+	require.NoError(t, head.Free(other))
+	require.ErrorIs(t, head.Free(a), ErrArenaOffset)
+	require.Nil(t, Offset[arenaNode](0).Get(a))
+	require.Nil(t, Offset[uint64](1<<40).Get(a))
+
+	_, err = ArenaNew[struct{ S []byte }](a)
+	require.ErrorContains(t, err, "is a slice")
+	_, err = OpenArena(seg, a.lock)
+	require.NoError(t, err)
+
+	// a segment without an arena
+	s2, id2 := testSegment(t, 4096)
+	plain, err := s2.Attach(id2, 0)
+	require.NoError(t, err)
+	defer plain.Close()
+	_, err = OpenArena(plain, a.lock)
+	require.ErrorIs(t, err, ErrSegmentMagic)
+}
+
+func TestArena_Concurrent(t *testing.T) {
+	_, _, a := testArena(t, 256<<10)
+
+	// require must not fail the test from other goroutines, errors are checked after Wait
+	errs := make([]error, 4)
+	var wg sync.WaitGroup
+	for g := range errs {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			errs[g] = testArenaWorker(a, byte(g))
+		}(g)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	require.Zero(t, a.Used())
+	require.Zero(t, a.Allocs())
+}
+
+// testArenaWorker allocates and frees memory filled with fill, checking that nobody else wrote it
+func testArenaWorker(a *Arena, fill byte) error {
+	var offs []uint64
+	for i := 0; i < 200; i++ {
+		off, err := a.Alloc(16 + (i*37+int(fill))%400)
+		if err != nil {
+			return err
+		}
+		b := a.Bytes(off)
+		for j := range b {
+			b[j] = fill
+		}
+		offs = append(offs, off)
+		if i%3 == 0 {
+			off, offs = offs[0], offs[1:]
+			for _, c := range a.Bytes(off) {
+				if c != fill {
+					return fmt.Errorf("allocation at %d overwritten: %d, want %d", off, c, fill)
+				}
+			}
+			if err := a.Free(off); err != nil {
+				return err
+			}
+		}
+	}
+	for _, off := range offs {
+		if err := a.Free(off); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"syscall"
+)
+
+var ErrBridgeClosed = errors.New("[error] channel bridge closed")
+
+// ChanBridge ... Exposes a message queue as Go channels.
+// A background goroutine moves received messages into Receive(), another one sends
+// everything written to Send(). Failures are reported on Errors().
+// Close stops both goroutines; once it returns, Receive() and Errors() are closed
+// and messages still buffered in Send() are discarded. Nothing reads Send() after Close,
+// senders that may race with Close use SendMsg or select on Done()
+type ChanBridge struct {
+	q    *MessageQueue
+	in   chan Msg
+	out  chan Msg
+	errs chan error
+	done chan struct{}
+
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewChanBridge ... Starts bridging the queue msgid obtained from GetMsg.
+// Messages selected by msgType (see msgrcv(2)) are received, buffer bounds every channel
+func NewChanBridge(msgid int, msgType uint, buffer int) *ChanBridge {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &ChanBridge{
+		q:      NewMessageQueue(msgid),
+		in:     make(chan Msg, buffer),
+		out:    make(chan Msg, buffer),
+		errs:   make(chan error, buffer),
+		done:   make(chan struct{}),
+		cancel: cancel,
+	}
+	b.wg.Add(2)
+	go b.receiveLoop(ctx, msgType)
+	go b.sendLoop(ctx)
+	return b
+}
+
+// Receive ... Channel of received messages, closed when the bridge stops receiving
+func (b *ChanBridge) Receive() <-chan Msg {
+	return b.in
+}
+
+// Send ... Channel of messages to send. Closing it stops the sending goroutine once the buffer is drained.
+// A plain send blocks forever once the bridge is closed and the buffer is full
+func (b *ChanBridge) Send() chan<- Msg {
+	return b.out
+}
+
+// SendMsg ... Queues m on Send(), waiting for buffer space until ctx is done.
+// Fails with ErrBridgeClosed once Close has been called
+func (b *ChanBridge) SendMsg(ctx context.Context, m Msg) error {
+	select {
+	case <-b.done:
+		return ErrBridgeClosed
+	default:
+	}
+	select {
+	case b.out <- m:
+		return nil
+	case <-b.done:
+		return ErrBridgeClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done ... Channel closed by Close
+func (b *ChanBridge) Done() <-chan struct{} {
+	return b.done
+}
+
+// Errors ... Channel of send and receive errors, closed by Close
+func (b *ChanBridge) Errors() <-chan error {
+	return b.errs
+}
+
+// Close ... Stops the background goroutines and waits for them to exit
+func (b *ChanBridge) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+		b.cancel()
+		b.wg.Wait()
+		close(b.errs)
+	})
+	return nil
+}
+
+func (b *ChanBridge) receiveLoop(ctx context.Context, msgType uint) {
+	defer b.wg.Done()
+	defer close(b.in)
+	for {
+		mtype, data, err := b.q.receive(ctx, msgType)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.report(ctx, err)
+			// the queue is gone, nothing more will ever arrive
+			if errors.Is(err, syscall.EIDRM) || errors.Is(err, syscall.EINVAL) {
+				return
+			}
+			continue
+		}
+		select {
+		case b.in <- Msg{Mtype: mtype, Mtext: data}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *ChanBridge) sendLoop(ctx context.Context) {
+	defer b.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-b.out:
+			if !ok {
+				return
+			}
+			if err := b.q.Send(ctx, m.Mtype, m.Mtext); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				b.report(ctx, err)
+			}
+		}
+	}
+}
+
+func (b *ChanBridge) report(ctx context.Context, err error) {
+	select {
+	case b.errs <- err:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,80 @@
+package ipc
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestChanBridge_Pipeline(t *testing.T) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	b := NewChanBridge(msgid, 0, 4)
+	defer b.Close()
+
+	go func() {
+		for i := 0; i < 10; i++ {
+			b.Send() <- Msg{Mtype: uint(i + 1), Mtext: []byte(fmt.Sprintf("msg-%d", i))}
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		select {
+		case m := <-b.Receive():
+			require.EqualValues(t, i+1, m.Mtype)
+			require.Equal(t, fmt.Sprintf("msg-%d", i), string(m.Mtext))
+		case err := <-b.Errors():
+			t.Fatal(err)
+		case <-time.After(time.Second):
+			t.Fatal("timeout")
+		}
+	}
+}
+
+func TestChanBridge_Errors(t *testing.T) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	b := NewChanBridge(msgid, 0, 1)
+	b.Send() <- Msg{Mtype: 0, Mtext: []byte("invalid type")}
+	select {
+	case err := <-b.Errors():
+		require.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout")
+	}
+
+	require.NoError(t, b.Close())
+	_, ok := <-b.Receive()
+	require.False(t, ok)
+	_, ok = <-b.Errors()
+	require.False(t, ok)
+}
+
+func TestChanBridge_SendAfterClose(t *testing.T) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	b := NewChanBridge(msgid, 1<<20, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, b.SendMsg(ctx, Msg{Mtype: 1, Mtext: []byte("before")}))
+	require.NoError(t, b.Close())
+
+	// nothing drains Send() any more, the senders must not hang
+	require.ErrorIs(t, b.SendMsg(ctx, Msg{Mtype: 1, Mtext: []byte("after")}), ErrBridgeClosed)
+	for i := 0; i < 2; i++ {
+		select {
+		case b.Send() <- Msg{Mtype: 1, Mtext: []byte("after")}:
+		case <-b.Done():
+		case <-time.After(time.Second):
+			t.Fatal("send after Close blocked")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package ipc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// Codec ... Converts values to and from message payloads
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// CodecError ... Reports a failure to encode or decode a value,
+// as opposed to a failure to move the bytes through the queue
+type CodecError struct {
+	Op  string // "marshal" or "unmarshal"
+	Err error
+}
+
+func (e *CodecError) Error() string {
+	return fmt.Sprintf("codec %s: %v", e.Op, e.Err)
+}
+
+func (e *CodecError) Unwrap() error {
+	return e.Err
+}
+
+// GobCodec ... Encodes values with encoding/gob, every message carries its own type information
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// JSONCodec ... Encodes values with encoding/json
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// BinaryCodec ... Encodes pointer-free, fixed-size values with encoding/binary in little-endian order.
+// Types containing strings, slices, maps or pointers are rejected
+type BinaryCodec struct{}
+
+func (BinaryCodec) Marshal(v any) ([]byte, error) {
+	size := binary.Size(v)
+	if size < 0 {
+		return nil, fmt.Errorf("type %T has no fixed binary layout", v)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (BinaryCodec) Unmarshal(data []byte, v any) error {
+	size := binary.Size(v)
+	if size < 0 {
+		return fmt.Errorf("type %T has no fixed binary layout", v)
+	}
+	if len(data) != size {
+		return fmt.Errorf("payload of %d bytes does not match %d bytes of %s",
+			len(data), size, reflect.TypeOf(v).Elem())
+	}
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, v)
+}
@@ -0,0 +1,36 @@
+package ipc
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type codecPoint struct {
+	X, Y int32
+	Tag  [4]byte
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	want := codecPoint{X: 1, Y: -2, Tag: [4]byte{'a', 'b', 'c', 'd'}}
+	for name, c := range map[string]Codec{
+		"gob":    GobCodec{},
+		"json":   JSONCodec{},
+		"binary": BinaryCodec{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data, err := c.Marshal(&want)
+			require.NoError(t, err)
+			var got codecPoint
+			require.NoError(t, c.Unmarshal(data, &got))
+			require.Equal(t, want, got)
+		})
+	}
+}
+
+func TestBinaryCodec_Rejects(t *testing.T) {
+	_, err := BinaryCodec{}.Marshal(&struct{ S string }{"x"})
+	require.Error(t, err)
+
+	var p codecPoint
+	require.Error(t, BinaryCodec{}.Unmarshal([]byte{1, 2, 3}, &p))
+}
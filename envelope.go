@@ -0,0 +1,197 @@
+package ipc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Envelope format, all integers are BigEndian:
+//
+//	| magic uint16 | version uint8 | reserved uint8 | pid uint32 | stream uint32 | seq uint64 |
+//	| timestamp int64 (unix ns) | crc32 uint32 | content type len uint16 | content type | payload ... |
+//
+// crc32 (IEEE) covers the whole envelope with the crc field set to zero.
+// pid and stream identify the sender, seq increases by one per message and stream.
+
+const (
+	EnvelopeVersion = 1
+
+	envelopeMagic      = 0x4945 // "IE"
+	envelopeHeaderSize = 34
+	envelopeCRCOffset  = 28
+)
+
+var (
+	ErrEnvelopeShort    = errors.New("[error] envelope too short")
+	ErrEnvelopeMagic    = errors.New("[error] not an envelope")
+	ErrEnvelopeVersion  = errors.New("[error] unsupported envelope version")
+	ErrEnvelopeChecksum = errors.New("[error] envelope checksum mismatch")
+
+	envelopeStream atomic.Uint32
+)
+
+// Envelope ... Payload plus the metadata added by an EnvelopeSender
+type Envelope struct {
+	Version     uint8
+	SenderPID   int
+	Stream      uint32
+	Seq         uint64
+	Timestamp   time.Time
+	ContentType string
+	Payload     []byte
+
+	// Gap is filled in by EnvelopeReceiver: the number of messages of the same
+	// sender stream missing between the previous envelope and this one
+	Gap uint64
+}
+
+// Latency ... Time elapsed since the envelope was sent
+func (e *Envelope) Latency() time.Duration {
+	return time.Since(e.Timestamp)
+}
+
+// MarshalEnvelope ... Encodes e, the checksum is computed here
+func MarshalEnvelope(e *Envelope) ([]byte, error) {
+	if len(e.ContentType) > 1<<16-1 {
+		return nil, fmt.Errorf("[error] content type too long: %d", len(e.ContentType))
+	}
+	buf := make([]byte, envelopeHeaderSize+len(e.ContentType)+len(e.Payload))
+	binary.BigEndian.PutUint16(buf[0:], envelopeMagic)
+	buf[2] = EnvelopeVersion
+	binary.BigEndian.PutUint32(buf[4:], uint32(e.SenderPID))
+	binary.BigEndian.PutUint32(buf[8:], e.Stream)
+	binary.BigEndian.PutUint64(buf[12:], e.Seq)
+	binary.BigEndian.PutUint64(buf[20:], uint64(e.Timestamp.UnixNano()))
+	binary.BigEndian.PutUint16(buf[32:], uint16(len(e.ContentType)))
+	n := envelopeHeaderSize + copy(buf[envelopeHeaderSize:], e.ContentType)
+	copy(buf[n:], e.Payload)
+	binary.BigEndian.PutUint32(buf[envelopeCRCOffset:], crc32.ChecksumIEEE(buf))
+	return buf, nil
+}
+
+// UnmarshalEnvelope ... Decodes and verifies an envelope, Payload aliases data
+func UnmarshalEnvelope(data []byte) (*Envelope, error) {
+	if len(data) < envelopeHeaderSize {
+		return nil, ErrEnvelopeShort
+	}
+	if binary.BigEndian.Uint16(data[0:]) != envelopeMagic {
+		return nil, ErrEnvelopeMagic
+	}
+	if data[2] != EnvelopeVersion {
+		return nil, fmt.Errorf("%w: %d", ErrEnvelopeVersion, data[2])
+	}
+	n := envelopeHeaderSize + int(binary.BigEndian.Uint16(data[32:]))
+	if len(data) < n {
+		return nil, ErrEnvelopeShort
+	}
+
+	sum := binary.BigEndian.Uint32(data[envelopeCRCOffset:])
+	h := crc32.NewIEEE()
+	h.Write(data[:envelopeCRCOffset])
+	h.Write([]byte{0, 0, 0, 0})
+	h.Write(data[envelopeCRCOffset+4:])
+	if h.Sum32() != sum {
+		return nil, ErrEnvelopeChecksum
+	}
+
+	return &Envelope{
+		Version:     data[2],
+		SenderPID:   int(binary.BigEndian.Uint32(data[4:])),
+		Stream:      binary.BigEndian.Uint32(data[8:]),
+		Seq:         binary.BigEndian.Uint64(data[12:]),
+		Timestamp:   time.Unix(0, int64(binary.BigEndian.Uint64(data[20:]))),
+		ContentType: string(data[envelopeHeaderSize:n]),
+		Payload:     data[n:],
+	}, nil
+}
+
+// EnvelopeSender ... Wraps payloads into envelopes numbered from 1 on its own stream
+type EnvelopeSender struct {
+	q      MsgQueue
+	pid    int
+	stream uint32
+	seq    atomic.Uint64
+}
+
+func NewEnvelopeSender(q MsgQueue) *EnvelopeSender {
+	return &EnvelopeSender{
+		q:      q,
+		pid:    os.Getpid(),
+		stream: envelopeStream.Add(1),
+	}
+}
+
+// Send ... Sends payload in an envelope tagged with contentType
+func (s *EnvelopeSender) Send(ctx context.Context, msgType uint, contentType string, payload []byte) error {
+	data, err := MarshalEnvelope(&Envelope{
+		SenderPID:   s.pid,
+		Stream:      s.stream,
+		Seq:         s.seq.Add(1),
+		Timestamp:   time.Now(),
+		ContentType: contentType,
+		Payload:     payload,
+	})
+	if err != nil {
+		return err
+	}
+	return s.q.Send(ctx, msgType, data)
+}
+
+type envelopeSource struct {
+	pid    int
+	stream uint32
+}
+
+// EnvelopeReceiver ... Receives and verifies envelopes, tracking sequence gaps per sender stream
+type EnvelopeReceiver struct {
+	q    MsgQueue
+	mu   sync.Mutex
+	last map[envelopeSource]uint64
+	gaps uint64
+}
+
+func NewEnvelopeReceiver(q MsgQueue) *EnvelopeReceiver {
+	return &EnvelopeReceiver{
+		q:    q,
+		last: make(map[envelopeSource]uint64, 16),
+	}
+}
+
+// Receive ... Waits for the next envelope. Corrupt messages are consumed and reported
+// with ErrEnvelopeChecksum or the other envelope errors
+func (r *EnvelopeReceiver) Receive(ctx context.Context, msgType uint) (*Envelope, error) {
+	data, err := r.q.Receive(ctx, msgType)
+	if err != nil {
+		return nil, err
+	}
+	e, err := UnmarshalEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	src := envelopeSource{pid: e.SenderPID, stream: e.Stream}
+	r.mu.Lock()
+	if last, ok := r.last[src]; ok && e.Seq > last+1 {
+		e.Gap = e.Seq - last - 1
+		r.gaps += e.Gap
+	}
+	if e.Seq > r.last[src] {
+		r.last[src] = e.Seq
+	}
+	r.mu.Unlock()
+	return e, nil
+}
+
+// Gaps ... Returns the total number of missing messages detected so far
+func (r *EnvelopeReceiver) Gaps() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gaps
+}
@@ -0,0 +1,82 @@
+package ipc
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	e := &Envelope{
+		SenderPID:   42,
+		Stream:      3,
+		Seq:         7,
+		Timestamp:   time.Unix(0, 123456789),
+		ContentType: "application/json",
+		Payload:     []byte(`{"a":1}`),
+	}
+	data, err := MarshalEnvelope(e)
+	require.NoError(t, err)
+
+	got, err := UnmarshalEnvelope(data)
+	require.NoError(t, err)
+	require.EqualValues(t, EnvelopeVersion, got.Version)
+	require.Equal(t, e.SenderPID, got.SenderPID)
+	require.Equal(t, e.Stream, got.Stream)
+	require.Equal(t, e.Seq, got.Seq)
+	require.True(t, e.Timestamp.Equal(got.Timestamp))
+	require.Equal(t, e.ContentType, got.ContentType)
+	require.Equal(t, e.Payload, got.Payload)
+
+	data[len(data)-1] ^= 0xff
+	_, err = UnmarshalEnvelope(data)
+	require.ErrorIs(t, err, ErrEnvelopeChecksum)
+
+	data[2] = 9
+	_, err = UnmarshalEnvelope(data)
+	require.ErrorIs(t, err, ErrEnvelopeVersion)
+
+	_, err = UnmarshalEnvelope([]byte(want))
+	require.ErrorIs(t, err, ErrEnvelopeMagic)
+	_, err = UnmarshalEnvelope(data[:10])
+	require.ErrorIs(t, err, ErrEnvelopeShort)
+}
+
+func TestEnvelope_SendAndReceive(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	s := NewEnvelopeSender(q)
+	r := NewEnvelopeReceiver(q)
+
+	require.NoError(t, s.Send(ctx, 1, "text/plain", []byte("first")))
+	e, err := r.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, os.Getpid(), e.SenderPID)
+	require.EqualValues(t, 1, e.Seq)
+	require.Equal(t, "text/plain", e.ContentType)
+	require.Equal(t, "first", string(e.Payload))
+	require.GreaterOrEqual(t, e.Latency(), time.Duration(0))
+
+	// the second message goes elsewhere, the receiver sees a gap of one
+	require.NoError(t, s.Send(ctx, 2, "text/plain", []byte("lost")))
+	require.NoError(t, s.Send(ctx, 1, "text/plain", []byte("third")))
+	e, err = r.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, e.Seq)
+	require.EqualValues(t, 1, e.Gap)
+	require.EqualValues(t, 1, r.Gaps())
+
+	// a second sender in the same process has its own stream
+	require.NoError(t, NewEnvelopeSender(q).Send(ctx, 1, "", nil))
+	e, err = r.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, e.Seq)
+	require.Zero(t, e.Gap)
+}
@@ -1,8 +1,10 @@
 package ipc
 
 import (
+	"context"
 	"sync"
 	"syscall"
+	"time"
 )
 
 type LockType int8
@@ -14,11 +16,40 @@ type Lock interface {
 	Close()
 }
 
+// MsgQueue ... Message queue operations shared by the System V (MessageQueue)
+// and POSIX (PosixQueue) backends. msgType is the message type for System V queues
+// and the priority for POSIX queues; portable callers send with msgType >= 1
+// and receive with msgType 0
+type MsgQueue interface {
+	Send(ctx context.Context, msgType uint, data []byte) error
+	Receive(ctx context.Context, msgType uint) ([]byte, error)
+	Close() error
+	Remove() error
+}
+
+var (
+	_ MsgQueue = (*MessageQueue)(nil)
+	_ MsgQueue = (*PosixQueue)(nil)
+)
+
 const (
 	SemLockMode LockType = 0
 	FlockMode   LockType = 1
 )
 
+// IpcPerm ... Mirrors the kernel ipc64_perm structure shared by msgctl, semctl and shmctl
+type IpcPerm struct {
+	Key  int32
+	Uid  uint32 // owner's user id
+	Gid  uint32 // owner's group id
+	Cuid uint32 // creator's user id
+	Cgid uint32 // creator's group id
+	Mode uint32 // read/write permission bits
+	Seq  uint16
+	_    uint16
+	_    [2]uint64
+}
+
 // Ftok ... Generates a key that is likely to be unique for use with System V IPC
 func Ftok(path string, id uint64) (uint64, error) {
 	st := &syscall.Stat_t{}
@@ -49,3 +80,11 @@ func NewLock(path string, mode LockType, ftokIds ...uint64) (Lock, error) {
 	}
 	return nil, nil
 }
+
+// unixTime converts seconds since the epoch, with 0 meaning never, to time.Time
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
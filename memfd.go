@@ -0,0 +1,188 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Anonymous shared memory created with memfd_create(2). The memory has no name in any
+// global namespace: it is shared by passing the descriptor to another process over a
+// Unix domain socket (SCM_RIGHTS) and freed when the last descriptor and mapping are gone,
+// so nothing is left behind after a crash. Seals (fcntl(2) F_ADD_SEALS) let a receiver trust
+// that the size and contents can no longer change.
+
+const (
+	/* Flags for `memfd_create'.  */
+	MFD_CLOEXEC       = 0x0001
+	MFD_ALLOW_SEALING = 0x0002
+
+	/* fcntl commands for file seals.  */
+	F_ADD_SEALS = 1033
+	F_GET_SEALS = 1034
+
+	F_SEAL_SEAL         = 0x0001 // prevent adding further seals
+	F_SEAL_SHRINK       = 0x0002 // prevent shrinking the file
+	F_SEAL_GROW         = 0x0004 // prevent growing the file
+	F_SEAL_WRITE        = 0x0008 // prevent any write, needs all writable mappings gone
+	F_SEAL_FUTURE_WRITE = 0x0010 // prevent new writes, existing writable mappings stay
+)
+
+var ErrMemfdSeals = errors.New("[error] memfd is missing required seals")
+
+// MemfdCreate ... Creates an anonymous memory file and returns its descriptor.
+// name only appears in /proc/<pid>/fd, flags is a combination of MFD_CLOEXEC and MFD_ALLOW_SEALING
+func MemfdCreate(name string, flags int) (int, error) {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return -1, err
+	}
+	fd, _, errno := syscall.Syscall(sysMemfdCreate, uintptr(unsafe.Pointer(p)), uintptr(flags), 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// Memfd ... Handle to an anonymous memory file
+type Memfd struct {
+	file *os.File
+}
+
+// CreateMemfd ... Creates an anonymous memory file of size bytes that allows sealing
+func CreateMemfd(name string, size int64) (*Memfd, error) {
+	fd, err := MemfdCreate(name, MFD_CLOEXEC|MFD_ALLOW_SEALING)
+	if err != nil {
+		return nil, err
+	}
+	m := &Memfd{file: os.NewFile(uintptr(fd), "memfd:"+name)}
+	if err := m.file.Truncate(size); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// Fd ... Returns the descriptor
+func (m *Memfd) Fd() int {
+	return int(m.file.Fd())
+}
+
+// Size ... Returns the current size in bytes
+func (m *Memfd) Size() (int64, error) {
+	st, err := m.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+// Truncate ... Changes the size, fails with EPERM once sealed against it
+func (m *Memfd) Truncate(size int64) error {
+	return m.file.Truncate(size)
+}
+
+// Map ... Maps the whole file as a Segment. With F_SEAL_WRITE applied only
+// read-only mappings are possible
+func (m *Memfd) Map(writable bool) (*Segment, error) {
+	size, err := m.Size()
+	if err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("[error] %s is empty", m.file.Name())
+	}
+	prot := syscall.PROT_READ
+	if writable {
+		prot |= syscall.PROT_WRITE
+	}
+	data, err := syscall.Mmap(m.Fd(), 0, int(size), prot, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &Segment{
+		id:       -1,
+		data:     data,
+		readOnly: !writable,
+		detach:   syscall.Munmap,
+		// nothing to unlink, the memory goes away with the last descriptor and mapping
+		remove: func() error { return nil },
+	}, nil
+}
+
+// Seal ... Adds seals, a combination of the F_SEAL_* flags.
+// F_SEAL_WRITE fails with EBUSY while a writable mapping exists
+func (m *Memfd) Seal(seals int) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, m.file.Fd(), F_ADD_SEALS, uintptr(seals))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Seals ... Returns the seals applied so far
+func (m *Memfd) Seals() (int, error) {
+	seals, _, errno := syscall.Syscall(syscall.SYS_FCNTL, m.file.Fd(), F_GET_SEALS, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(seals), nil
+}
+
+// Close ... Closes the descriptor, mappings stay valid until their Segment is closed
+func (m *Memfd) Close() error {
+	return m.file.Close()
+}
+
+// SendMemfd ... Passes the descriptor of m to the peer of conn
+func SendMemfd(conn *net.UnixConn, m *Memfd) error {
+	_, _, err := conn.WriteMsgUnix([]byte{0}, syscall.UnixRights(m.Fd()), nil)
+	return err
+}
+
+// ReceiveMemfd ... Receives a descriptor sent by SendMemfd and checks that at least
+// the given seals are applied, so the sender can no longer resize or modify the memory
+func ReceiveMemfd(conn *net.UnixConn, seals int) (*Memfd, error) {
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, flags, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for i := range msgs {
+		rights, err := syscall.ParseUnixRights(&msgs[i])
+		if err == nil {
+			fds = append(fds, rights...)
+		}
+	}
+	if len(fds) == 0 {
+		return nil, errors.New("[error] no descriptor received")
+	}
+	for _, fd := range fds[1:] {
+		syscall.Close(fd)
+	}
+	syscall.CloseOnExec(fds[0])
+	m := &Memfd{file: os.NewFile(uintptr(fds[0]), "memfd")}
+	if flags&syscall.MSG_CTRUNC != 0 {
+		m.Close()
+		return nil, errors.New("[error] control message truncated")
+	}
+	got, err := m.Seals()
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	if got&seals != seals {
+		m.Close()
+		return nil, fmt.Errorf("%w: have %#x, want %#x", ErrMemfdSeals, got, seals)
+	}
+	return m, nil
+}
@@ -0,0 +1,4 @@
+package ipc
+
+// the frozen syscall package lacks SYS_MEMFD_CREATE on amd64
+const sysMemfdCreate = 319
@@ -0,0 +1,4 @@
+package ipc
+
+// the frozen syscall package lacks SYS_MEMFD_CREATE on arm
+const sysMemfdCreate = 385
@@ -0,0 +1,6 @@
+//go:build mips || mipsle
+
+package ipc
+
+// the frozen syscall package lacks SYS_MEMFD_CREATE on mips (o32)
+const sysMemfdCreate = 4354
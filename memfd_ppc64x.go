@@ -0,0 +1,6 @@
+//go:build ppc64 || ppc64le
+
+package ipc
+
+// the frozen syscall package lacks SYS_MEMFD_CREATE on ppc64
+const sysMemfdCreate = 360
@@ -0,0 +1,81 @@
+package ipc
+
+import (
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func testUnixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	require.NoError(t, err)
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		c, err := net.FileConn(f)
+		f.Close()
+		require.NoError(t, err)
+		conns[i] = c.(*net.UnixConn)
+		t.Cleanup(func() { c.Close() })
+	}
+	return conns[0], conns[1]
+}
+
+func TestMemfd_SendSealed(t *testing.T) {
+	a, b := testUnixPair(t)
+
+	m, err := CreateMemfd("test", 4096)
+	require.NoError(t, err)
+	defer m.Close()
+
+	seg, err := m.Map(true)
+	require.NoError(t, err)
+	_, err = seg.WriteAt([]byte(want), 0)
+	require.NoError(t, err)
+
+	// writes cannot be sealed while a writable mapping exists
+	require.ErrorIs(t, m.Seal(F_SEAL_WRITE), syscall.EBUSY)
+	require.NoError(t, seg.Close())
+	seals := F_SEAL_SHRINK | F_SEAL_GROW | F_SEAL_WRITE
+	require.NoError(t, m.Seal(seals|F_SEAL_SEAL))
+
+	require.NoError(t, SendMemfd(a, m))
+	got, err := ReceiveMemfd(b, seals)
+	require.NoError(t, err)
+	defer got.Close()
+	require.NotEqual(t, m.Fd(), got.Fd())
+
+	size, err := got.Size()
+	require.NoError(t, err)
+	require.EqualValues(t, 4096, size)
+	require.ErrorIs(t, got.Truncate(8192), syscall.EPERM)
+	_, err = got.Map(true)
+	require.ErrorIs(t, err, syscall.EPERM)
+
+	view, err := got.Map(false)
+	require.NoError(t, err)
+	defer view.Close()
+	require.Equal(t, want, string(view.Bytes()[:len(want)]))
+}
+
+func TestMemfd_MissingSeals(t *testing.T) {
+	a, b := testUnixPair(t)
+
+	m, err := CreateMemfd("unsealed", 4096)
+	require.NoError(t, err)
+	defer m.Close()
+	require.NoError(t, m.Seal(F_SEAL_SHRINK))
+
+	require.NoError(t, SendMemfd(a, m))
+	_, err = ReceiveMemfd(b, F_SEAL_SHRINK|F_SEAL_WRITE)
+	require.ErrorIs(t, err, ErrMemfdSeals)
+
+	// the sender may still write
+	seg, err := m.Map(true)
+	require.NoError(t, err)
+	defer seg.Close()
+	_, err = seg.WriteAt([]byte("x"), 0)
+	require.NoError(t, err)
+}
@@ -2,16 +2,41 @@ package ipc
 
 import (
 	"errors"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
 const (
+	// msgSize is the message size limit of the free functions and the default of MessageQueue
 	msgSize = 1 << 13
 	/* Define options for message queue functions.  */
-	MSG_BLOCK = 0
+	MSG_BLOCK   = 0
+	MSG_NOERROR = 010000 // no error if message is too big, truncate it
+	MSG_EXCEPT  = 020000 // receive any message except of the specified type
+	MSG_COPY    = 040000 // copy (not remove) the message at the position given by msgtyp
+
+	/* ipcs ctl commands */
+	MSG_STAT     = 11
+	MSG_INFO     = 12
+	MSG_STAT_ANY = 13
 )
 
+// ErrQueueFull ... Returned by non-blocking sends when the queue has no room for the message.
+// It matches syscall.EAGAIN with errors.Is
+var ErrQueueFull error = queueFullError{}
+
+type queueFullError struct{}
+
+func (queueFullError) Error() string { return "[error] message queue full" }
+
+func (queueFullError) Is(target error) bool { return target == syscall.EAGAIN }
+
 // GetMsg ... Retrieves the message queue identifier if it exists,
 // or creates a new message queue object if not found, and returns the corresponding identifier
 func GetMsg(key uint64, msgflag int) (int, error) {
@@ -22,12 +47,12 @@ func GetMsg(key uint64, msgflag int) (int, error) {
 	return int(id), nil
 }
 
-func receiveMsg(msgid int, msgp uintptr, msgtyp uint, msgflg int) (uintptr, error) {
+func receiveMsg(msgid int, msgp uintptr, msgsz int, msgtyp int64, msgflg int) (uintptr, error) {
 	r, _, err := syscall.Syscall6(
 		syscall.SYS_MSGRCV,
 		uintptr(msgid),
 		msgp,
-		uintptr(msgSize),
+		uintptr(msgsz),
 		uintptr(msgtyp),
 		uintptr(msgflg),
 		0,
@@ -39,12 +64,112 @@ func receiveMsg(msgid int, msgp uintptr, msgtyp uint, msgflg int) (uintptr, erro
 }
 
 func ReceiveMsg(msgid int, msgType uint, flag int) ([]byte, error) {
-	m := Message{Mtype: msgType}
-	readLen, err := receiveMsg(msgid, uintptr(unsafe.Pointer(&m)), msgType, flag)
+	_, data, err := receiveMessage(msgid, msgType, msgSize, flag)
+	return data, err
+}
+
+// receiveMessage is ReceiveMsg for messages of up to size bytes that also reports the type of the received message
+func receiveMessage(msgid int, msgType uint, size int, flag int) (uint, []byte, error) {
+	m := getMsgBuffer(size)
+	defer putMsgBuffer(m)
+	return m.receive(msgid, msgType, size, flag)
+}
+
+// ReceiveMsgInto ... Receives a message into buf and returns its length and type.
+// Messages longer than buf fail with E2BIG, or are truncated when flag contains MSG_NOERROR.
+// In the steady state it does not allocate
+func ReceiveMsgInto(msgid int, msgType uint, buf []byte, flag int) (int, uint, error) {
+	m := getMsgBuffer(len(buf))
+	defer putMsgBuffer(m)
+	readLen, err := receiveMsg(msgid, m.ptr(), len(buf), int64(msgType), flag)
+	if err != nil {
+		return 0, 0, err
+	}
+	return copy(buf, m.text()[:readLen]), m.mtype(), nil
+}
+
+// ReceiveOptions ... Selects which message msgrcv picks and how it is copied out
+type ReceiveOptions struct {
+	// Type is msgtyp of msgrcv(2):
+	//   - 0: the first message in the queue
+	//   - > 0: the first message of that type, or of any other type with Except
+	//   - < 0: the first message with the lowest type <= -Type, i.e. priority receive
+	Type int64
+	// Except receives the first message whose type differs from Type (MSG_EXCEPT)
+	Except bool
+	// NoError truncates messages longer than MaxSize instead of failing with E2BIG (MSG_NOERROR)
+	NoError bool
+	// Copy returns a copy of the message at position Index without removing it (MSG_COPY).
+	// It never blocks and fails with ENOMSG past the last message, Type and Except are ignored.
+	// The kernel must be built with CONFIG_CHECKPOINT_RESTORE, otherwise ENOSYS is returned
+	Copy  bool
+	Index int
+	// NoWait fails with ENOMSG instead of blocking when no message matches (IPC_NOWAIT)
+	NoWait bool
+	// MaxSize bounds the received text, 0 selects msgSize or the MaxSize of the MessageQueue
+	MaxSize int
+}
+
+// ReceivePriority ... Options receiving the message with the lowest type not above maxType,
+// so lower types act as higher priority lanes
+func ReceivePriority(maxType uint) ReceiveOptions {
+	return ReceiveOptions{Type: -int64(maxType)}
+}
+
+// ReceiveExcept ... Options receiving the first message of any type except msgType
+func ReceiveExcept(msgType uint) ReceiveOptions {
+	return ReceiveOptions{Type: int64(msgType), Except: true}
+}
+
+// ReceivePeek ... Options copying the message at position index without removing it
+func ReceivePeek(index int) ReceiveOptions {
+	return ReceiveOptions{Copy: true, Index: index, NoWait: true}
+}
+
+// Received ... Message returned by ReceiveMsgWith
+type Received struct {
+	Mtype uint
+	Data  []byte
+	// Truncated is set when NoError cut the message down to MaxSize
+	Truncated bool
+}
+
+// ReceiveMsgWith ... Receives a message using the modes selected by opts
+func ReceiveMsgWith(msgid int, opts ReceiveOptions) (*Received, error) {
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = msgSize
+	}
+	msgtyp, flag := opts.Type, 0
+	if opts.Except {
+		flag |= MSG_EXCEPT
+	}
+	if opts.Copy {
+		msgtyp, flag = int64(opts.Index), MSG_COPY|IPC_NOWAIT
+	}
+	if opts.NoWait {
+		flag |= IPC_NOWAIT
+	}
+	msgsz := maxSize
+	if opts.NoError {
+		// one spare byte tells a truncated message apart from one of exactly maxSize bytes
+		flag |= MSG_NOERROR
+		msgsz++
+	}
+
+	m := &msgBuffer{b: make([]byte, msgTypeSize+msgsz)}
+	readLen, err := receiveMsg(msgid, m.ptr(), msgsz, msgtyp, flag)
 	if err != nil {
 		return nil, err
 	}
-	return m.Mtext[:readLen], nil
+	r := &Received{
+		Mtype: m.mtype(),
+		Data:  m.text()[:readLen],
+	}
+	if int(readLen) > maxSize {
+		r.Data, r.Truncated = r.Data[:maxSize], true
+	}
+	return r, nil
 }
 
 func sendMsg(msgid int, msgp uintptr, msgsz int, msgflg int) error {
@@ -57,24 +182,55 @@ func sendMsg(msgid int, msgp uintptr, msgsz int, msgflg int) error {
 		0,
 		0,
 	)
+	if err == syscall.EAGAIN {
+		return ErrQueueFull
+	}
 	if err != 0 {
 		return err
 	}
 	return nil
 }
 
+// SendMsg ... Sends a message of the given type. With IPC_NOWAIT in flags a full queue
+// fails with ErrQueueFull instead of blocking
 func SendMsg(msgid int, msgType uint, msgText []byte, flags int) error {
-	if len(msgText) > msgSize {
+	return sendMessage(msgid, msgType, msgText, msgSize, flags)
+}
+
+// sendMessage is SendMsg for messages of up to size bytes
+func sendMessage(msgid int, msgType uint, msgText []byte, size int, flags int) error {
+	if len(msgText) > size {
 		return errors.New("[error] message length too long")
 	}
-	m := Message{Mtype: msgType}
-	copy(m.Mtext[:], msgText)
-	return sendMsg(msgid, uintptr(unsafe.Pointer(&m)), len(msgText), flags)
+	m := getMsgBuffer(len(msgText))
+	defer putMsgBuffer(m)
+	m.setMtype(msgType)
+	copy(m.text(), msgText)
+	return sendMsg(msgid, m.ptr(), len(msgText), flags)
+}
+
+// MsgMax ... Returns the kernel limit on the size of a single message (kernel.msgmax)
+func MsgMax() (int, error) {
+	data, err := os.ReadFile("/proc/sys/kernel/msgmax")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
 }
 
 // RemoveMsg ... Removes the message queue associated with the given identifier
 func RemoveMsg(msgid int) error {
-	_, _, err := syscall.Syscall(syscall.SYS_MSGCTL, uintptr(msgid), IPC_RMID, 0)
+	return Msgctl(msgid, IPC_RMID, nil)
+}
+
+// Msgctl ... Full control over message queue resources
+// cmd:
+// IPC_STAT: Copy the msqid_ds structure of the queue into buf.
+// IPC_SET: Write the uid, gid, mode and qbytes fields of buf into the msqid_ds structure of the queue.
+// Raising qbytes above the msgmnb limit requires CAP_SYS_RESOURCE.
+// IPC_RMID: Remove the queue, buf is ignored and may be nil
+func Msgctl(msgid, cmd int, buf *MsqidDs) error {
+	_, _, err := syscall.Syscall(syscall.SYS_MSGCTL, uintptr(msgid), uintptr(cmd), uintptr(unsafe.Pointer(buf)))
 	if err != 0 {
 		return err
 	}
@@ -86,9 +242,97 @@ type Message struct {
 	Mtext [msgSize]byte
 }
 
+// Msg ... A message together with its type, holding just the text rather than
+// the fixed msgSize array of Message. Used by ChanBridge and the batch operations
+type Msg struct {
+	Mtype uint
+	Mtext []byte
+}
+
+const msgTypeSize = int(unsafe.Sizeof(uint(0)))
+
+// msgBuffer is the buffer handed to msgsnd and msgrcv:
+// the message type as a native long followed by the message text
+type msgBuffer struct {
+	b []byte
+}
+
+// msgBufferPools recycle syscall buffers so the hot paths do not allocate per call,
+// pool i holds buffers with room for 1<<i bytes of text
+var msgBufferPools [65]sync.Pool
+
+// getMsgBuffer returns a buffer with room for at least size bytes of text
+func getMsgBuffer(size int) *msgBuffer {
+	class := bits.Len(uint(max(size, 1) - 1))
+	if m, ok := msgBufferPools[class].Get().(*msgBuffer); ok {
+		return m
+	}
+	return &msgBuffer{b: make([]byte, msgTypeSize+1<<class)}
+}
+
+func putMsgBuffer(m *msgBuffer) {
+	msgBufferPools[bits.Len(uint(len(m.text())-1))].Put(m)
+}
+
+func (m *msgBuffer) ptr() uintptr {
+	return uintptr(unsafe.Pointer(&m.b[0]))
+}
+
+func (m *msgBuffer) mtype() uint {
+	return *(*uint)(unsafe.Pointer(&m.b[0]))
+}
+
+func (m *msgBuffer) setMtype(t uint) {
+	*(*uint)(unsafe.Pointer(&m.b[0])) = t
+}
+
+func (m *msgBuffer) text() []byte {
+	return m.b[msgTypeSize:]
+}
+
+// receive reads one message of up to size bytes into m and returns a copy of its text
+func (m *msgBuffer) receive(msgid int, msgType uint, size int, flag int) (uint, []byte, error) {
+	readLen, err := receiveMsg(msgid, m.ptr(), size, int64(msgType), flag)
+	if err != nil {
+		return 0, nil, err
+	}
+	data := make([]byte, readLen)
+	copy(data, m.text()[:readLen])
+	return m.mtype(), data, nil
+}
+
 // The msgp argument is a pointer to a caller-defined structure of the
 // following general form
 type msgbuf struct {
 	Mtype uint
 	Mtext [0]byte
 }
+
+// MsqidDs ... Mirrors the kernel msqid64_ds structure used by msgctl
+type MsqidDs struct {
+	Perm   IpcPerm
+	Stime  int64  // time of last msgsnd
+	Rtime  int64  // time of last msgrcv
+	Ctime  int64  // time of last change
+	Cbytes uint64 // current number of bytes in the queue
+	Qnum   uint64 // number of messages in the queue
+	Qbytes uint64 // max number of bytes allowed in the queue
+	Lspid  int32  // pid of last msgsnd
+	Lrpid  int32  // pid of last msgrcv
+	_      [2]uint64
+}
+
+// SendTime ... Time of the last msgsnd, zero if none
+func (ds *MsqidDs) SendTime() time.Time {
+	return unixTime(ds.Stime)
+}
+
+// ReceiveTime ... Time of the last msgrcv, zero if none
+func (ds *MsqidDs) ReceiveTime() time.Time {
+	return unixTime(ds.Rtime)
+}
+
+// ChangeTime ... Time of the last change made by msgget or IPC_SET
+func (ds *MsqidDs) ChangeTime() time.Time {
+	return unixTime(ds.Ctime)
+}
@@ -1,10 +1,14 @@
 package ipc
 
 import (
+	"errors"
 	"github.com/stretchr/testify/require"
+	"os"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 var want = strings.Repeat("1", 128)
@@ -41,3 +45,158 @@ func TestMsg_SendAndRec(t *testing.T) {
 
 	<-done
 }
+
+func TestMsgctl_StatAndSet(t *testing.T) {
+	require.EqualValues(t, 48, unsafe.Sizeof(IpcPerm{}))
+	require.EqualValues(t, 120, unsafe.Sizeof(MsqidDs{}))
+
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	require.NoError(t, SendMsg(msgid, 1, []byte(want), IPC_NOWAIT))
+	require.NoError(t, SendMsg(msgid, 1, []byte(want), IPC_NOWAIT))
+
+	ds := &MsqidDs{}
+	require.NoError(t, Msgctl(msgid, IPC_STAT, ds))
+	require.EqualValues(t, 2, ds.Qnum)
+	require.EqualValues(t, 2*len(want), ds.Cbytes)
+	require.EqualValues(t, os.Getpid(), ds.Lspid)
+	require.EqualValues(t, IPC_RW, ds.Perm.Mode&0777)
+	require.False(t, ds.SendTime().IsZero())
+	require.True(t, ds.ReceiveTime().IsZero())
+
+	ds.Perm.Mode = IPC_R | IPC_W | 0040
+	ds.Qbytes /= 2
+	require.NoError(t, Msgctl(msgid, IPC_SET, ds))
+
+	got := &MsqidDs{}
+	require.NoError(t, Msgctl(msgid, IPC_STAT, got))
+	require.EqualValues(t, 0640, got.Perm.Mode&0777)
+	require.Equal(t, ds.Qbytes, got.Qbytes)
+}
+
+func TestReceiveMsgWith_Modes(t *testing.T) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	for _, m := range []Msg{
+		{Mtype: 5, Mtext: []byte("five")},
+		{Mtype: 3, Mtext: []byte("three")},
+		{Mtype: 1, Mtext: []byte("one, but long")},
+		{Mtype: 2, Mtext: []byte("two")},
+	} {
+		require.NoError(t, SendMsg(msgid, m.Mtype, m.Mtext, IPC_NOWAIT))
+	}
+
+	// lowest type first among types <= 3
+	opts := ReceivePriority(3)
+	opts.NoWait = true
+	opts.NoError = true
+	opts.MaxSize = 3
+	r, err := ReceiveMsgWith(msgid, opts)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, r.Mtype)
+	require.Equal(t, "one", string(r.Data))
+	require.True(t, r.Truncated)
+
+	_, err = ReceiveMsgWith(msgid, ReceiveOptions{Type: 3, MaxSize: 2, NoWait: true})
+	require.ErrorIs(t, err, syscall.E2BIG)
+
+	r, err = ReceiveMsgWith(msgid, ReceiveExcept(5))
+	require.NoError(t, err)
+	require.EqualValues(t, 3, r.Mtype)
+	require.False(t, r.Truncated)
+
+	r, err = ReceiveMsgWith(msgid, ReceivePriority(10))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, r.Mtype)
+}
+
+func TestReceiveMsgWith_Peek(t *testing.T) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	require.NoError(t, SendMsg(msgid, 1, []byte("first"), IPC_NOWAIT))
+	require.NoError(t, SendMsg(msgid, 2, []byte("second"), IPC_NOWAIT))
+
+	r, err := ReceiveMsgWith(msgid, ReceivePeek(1))
+	if errors.Is(err, syscall.ENOSYS) {
+		t.Skip("kernel built without MSG_COPY support")
+	}
+	require.NoError(t, err)
+	require.EqualValues(t, 2, r.Mtype)
+	require.Equal(t, "second", string(r.Data))
+
+	_, err = ReceiveMsgWith(msgid, ReceivePeek(2))
+	require.ErrorIs(t, err, syscall.ENOMSG)
+
+	got, err := ReceiveMsg(msgid, 0, IPC_NOWAIT)
+	require.NoError(t, err)
+	require.Equal(t, "first", string(got))
+}
+
+func TestReceiveMsgInto(t *testing.T) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	buf := make([]byte, 64)
+	require.NoError(t, SendMsg(msgid, 3, []byte(want[:10]), IPC_NOWAIT))
+	n, mtype, err := ReceiveMsgInto(msgid, 0, buf, IPC_NOWAIT)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, mtype)
+	require.Equal(t, want[:10], string(buf[:n]))
+
+	require.NoError(t, SendMsg(msgid, 1, []byte(want), IPC_NOWAIT))
+	_, _, err = ReceiveMsgInto(msgid, 0, buf, IPC_NOWAIT)
+	require.ErrorIs(t, err, syscall.E2BIG)
+	n, _, err = ReceiveMsgInto(msgid, 0, buf, IPC_NOWAIT|MSG_NOERROR)
+	require.NoError(t, err)
+	require.Equal(t, want[:64], string(buf[:n]))
+
+	// sync.Pool drops items at random under the race detector
+	if raceEnabled {
+		return
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = SendMsg(msgid, 1, buf[:16], IPC_NOWAIT)
+		_, _, _ = ReceiveMsgInto(msgid, 0, buf, IPC_NOWAIT)
+	})
+	require.Zero(t, allocs)
+}
+
+func BenchmarkReceiveMsg(b *testing.B) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(b, err)
+	defer RemoveMsg(msgid)
+	payload := []byte(want)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = SendMsg(msgid, 1, payload, IPC_NOWAIT)
+		if _, err := ReceiveMsg(msgid, 0, IPC_NOWAIT); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReceiveMsgInto(b *testing.B) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(b, err)
+	defer RemoveMsg(msgid)
+	payload := []byte(want)
+	buf := make([]byte, msgSize)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = SendMsg(msgid, 1, payload, IPC_NOWAIT)
+		if _, _, err := ReceiveMsgInto(msgid, 0, buf, IPC_NOWAIT); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
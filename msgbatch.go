@@ -0,0 +1,113 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"syscall"
+)
+
+// SendBatch ... Sends msgs in order and returns how many were sent.
+// On error, including ErrQueueFull when flags contains IPC_NOWAIT and the queue is full,
+// the messages before the returned count were sent and the rest were not
+func SendBatch(msgid int, msgs []Msg, flags int) (int, error) {
+	return sendBatch(msgid, msgs, msgSize, flags)
+}
+
+// sendBatch is SendBatch for messages of up to size bytes
+func sendBatch(msgid int, msgs []Msg, size int, flags int) (int, error) {
+	longest := 0
+	for _, msg := range msgs {
+		longest = max(longest, len(msg.Mtext))
+	}
+	m := getMsgBuffer(min(longest, size))
+	defer putMsgBuffer(m)
+	for i, msg := range msgs {
+		if len(msg.Mtext) > size {
+			return i, errors.New("[error] message length too long")
+		}
+		m.setMtype(msg.Mtype)
+		copy(m.text(), msg.Mtext)
+		if err := sendMsg(msgid, m.ptr(), len(msg.Mtext), flags); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// ReceiveBatch ... Receives up to max messages selected by msgType and flag. Only the first
+// receive may block, e.g. without IPC_NOWAIT; after it the messages already in the queue are
+// drained without blocking. MSG_EXCEPT and MSG_NOERROR apply to every message, with MSG_COPY
+// the batch copies the messages at positions msgType, msgType+1, ...
+func ReceiveBatch(msgid int, msgType uint, max int, flag int) ([]Msg, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	mtype, data, err := receiveMessage(msgid, msgType, msgSize, flag)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]Msg, 1, max)
+	msgs[0] = Msg{Mtype: mtype, Mtext: data}
+	return drainBatch(msgid, msgType, msgSize, flag, msgs, max)
+}
+
+// drainBatch appends messages of up to size bytes to msgs until the queue is empty or max is reached,
+// flag selects the messages like for the first receive of the batch
+func drainBatch(msgid int, msgType uint, size int, flag int, msgs []Msg, max int) ([]Msg, error) {
+	m := getMsgBuffer(size)
+	defer putMsgBuffer(m)
+	for len(msgs) < max {
+		if flag&MSG_COPY != 0 {
+			// copies leave the message in place, move on to the next position
+			msgType++
+		}
+		mtype, data, err := m.receive(msgid, msgType, size, flag|IPC_NOWAIT)
+		if errors.Is(err, syscall.ENOMSG) {
+			break
+		}
+		if err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, Msg{Mtype: mtype, Mtext: data})
+	}
+	return msgs, nil
+}
+
+// SendBatch ... Sends msgs in order, waiting while the queue is full, and returns how many
+// were sent. If ctx is done first, the count reports the partial progress
+func (q *MessageQueue) SendBatch(ctx context.Context, msgs []Msg) (int, error) {
+	sent := 0
+	delay := msgPollMin
+	for {
+		n, err := sendBatch(q.id, msgs[sent:], q.maxSize, IPC_NOWAIT)
+		sent += n
+		switch {
+		case err == nil:
+			return sent, nil
+		case errors.Is(err, ErrQueueFull), errors.Is(err, syscall.EINTR):
+		default:
+			return sent, err
+		}
+		if n > 0 {
+			delay = msgPollMin
+		}
+		if err := pollWait(ctx, &delay); err != nil {
+			return sent, err
+		}
+	}
+}
+
+// ReceiveBatch ... Waits for the first message selected by msgType or until ctx is done,
+// then drains up to max messages in total without waiting again
+func (q *MessageQueue) ReceiveBatch(ctx context.Context, msgType uint, max int) ([]Msg, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	mtype, data, err := q.receive(ctx, msgType)
+	if err != nil {
+		return nil, err
+	}
+	msgs := make([]Msg, 1, max)
+	msgs[0] = Msg{Mtype: mtype, Mtext: data}
+	return drainBatch(q.id, msgType, q.maxSize, 0, msgs, max)
+}
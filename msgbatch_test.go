@@ -0,0 +1,136 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testBatch(n, size int) []Msg {
+	msgs := make([]Msg, n)
+	for i := range msgs {
+		text := []byte(fmt.Sprintf("%0*d", size, i))
+		msgs[i] = Msg{Mtype: uint(i%3 + 1), Mtext: text}
+	}
+	return msgs
+}
+
+func TestSendBatch_Partial(t *testing.T) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	ds := &MsqidDs{}
+	require.NoError(t, Msgctl(msgid, IPC_STAT, ds))
+
+	// twice the queue capacity cannot fit
+	msgs := testBatch(int(ds.Qbytes)/512, 1024)
+	n, err := SendBatch(msgid, msgs, IPC_NOWAIT)
+	require.ErrorIs(t, err, ErrQueueFull)
+	require.Greater(t, n, 0)
+	require.Less(t, n, len(msgs))
+
+	got, err := ReceiveBatch(msgid, 0, len(msgs), IPC_NOWAIT)
+	require.NoError(t, err)
+	require.Equal(t, msgs[:n], got)
+
+	_, err = ReceiveBatch(msgid, 0, 10, IPC_NOWAIT)
+	require.ErrorIs(t, err, syscall.ENOMSG)
+}
+
+func TestReceiveBatch_Max(t *testing.T) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	msgs := testBatch(10, 8)
+	n, err := SendBatch(msgid, msgs, IPC_NOWAIT)
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+
+	got, err := ReceiveBatch(msgid, 2, 2, IPC_NOWAIT)
+	require.NoError(t, err)
+	require.Equal(t, []Msg{msgs[1], msgs[4]}, got)
+}
+
+func TestReceiveBatch_Flags(t *testing.T) {
+	msgid, err := GetMsg(IPC_PRIVATE, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer RemoveMsg(msgid)
+
+	// types 1, 2, 3, 1, 2, 3, ...
+	msgs := testBatch(9, 8)
+	_, err = SendBatch(msgid, msgs, IPC_NOWAIT)
+	require.NoError(t, err)
+
+	// copies at positions 2, 3 and 4 leave the queue untouched
+	got, err := ReceiveBatch(msgid, 2, 3, IPC_NOWAIT|MSG_COPY)
+	if errors.Is(err, syscall.ENOSYS) {
+		t.Log("kernel without CONFIG_CHECKPOINT_RESTORE, skipping MSG_COPY")
+	} else {
+		require.NoError(t, err)
+		require.Equal(t, msgs[2:5], got)
+	}
+
+	// every message of the batch excludes type 2
+	got, err = ReceiveBatch(msgid, 2, 10, IPC_NOWAIT|MSG_EXCEPT)
+	require.NoError(t, err)
+	require.Equal(t, []Msg{msgs[0], msgs[2], msgs[3], msgs[5], msgs[6], msgs[8]}, got)
+
+	got, err = ReceiveBatch(msgid, 0, 10, IPC_NOWAIT)
+	require.NoError(t, err)
+	require.Equal(t, []Msg{msgs[1], msgs[4], msgs[7]}, got)
+
+	// oversized messages after the first one are truncated rather than failing the batch
+	require.NoError(t, SendMsg(msgid, 1, []byte("short"), IPC_NOWAIT))
+	require.NoError(t, SendMsg(msgid, 1, []byte(want), IPC_NOWAIT))
+	got, err = drainBatch(msgid, 0, 16, MSG_NOERROR, nil, 2)
+	require.NoError(t, err)
+	require.Equal(t, []Msg{{Mtype: 1, Mtext: []byte("short")}, {Mtype: 1, Mtext: []byte(want[:16])}}, got)
+}
+
+func TestMessageQueue_Batch(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	msgs := testBatch(200, 512)
+	done := make(chan []Msg, 1)
+	received := make(chan error, 1)
+	go func() {
+		var got []Msg
+		for len(got) < len(msgs) {
+			batch, err := q.ReceiveBatch(ctx, 0, 32)
+			if err == nil && len(batch) > 32 {
+				err = fmt.Errorf("batch of %d messages, want at most 32", len(batch))
+			}
+			if err != nil {
+				received <- err
+				return
+			}
+			got = append(got, batch...)
+		}
+		done <- got
+		received <- nil
+	}()
+
+	n, err := q.SendBatch(ctx, msgs)
+	require.NoError(t, err)
+	require.Equal(t, len(msgs), n)
+	require.NoError(t, <-received)
+	require.Equal(t, msgs, <-done)
+
+	sctx, scancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer scancel()
+	n, err = q.SendBatch(sctx, msgs)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Greater(t, n, 0)
+	require.Less(t, n, len(msgs))
+}
@@ -0,0 +1,213 @@
+package ipc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fragmentation of payloads larger than a single message.
+// Every fragment starts with a fixed header, all integers are BigEndian:
+//
+//	| pid uint32 | seq uint64 | index uint32 | count uint32 | total uint32 | offset uint32 | payload ... |
+//
+// pid and seq identify the logical message, so fragments coming from
+// several senders may interleave freely in the queue. offset places the payload
+// in the message, so peers do not need to agree on the fragment size.
+
+const (
+	fragHeaderSize = 28
+
+	// DefaultFragmentTimeout ... How long a Reassembler keeps an incomplete message
+	DefaultFragmentTimeout = 30 * time.Second
+)
+
+var (
+	ErrFragmentHeader = errors.New("[error] malformed fragment header")
+
+	fragSeq atomic.Uint64
+)
+
+// SendLarge ... Sends data of any size, splitting it into numbered fragments
+// that a Reassembler on the receiving side puts back together.
+// All fragments are sent with msgType; the queue must only carry fragmented messages for that type.
+// Fragments fill up to MaxSize bytes of the queue
+func (q *MessageQueue) SendLarge(ctx context.Context, msgType uint, data []byte) error {
+	fragPayloadSize := q.maxSize - fragHeaderSize
+	if fragPayloadSize <= 0 {
+		return fmt.Errorf("[error] max message size %d too small for fragments", q.maxSize)
+	}
+	count := (len(data) + fragPayloadSize - 1) / fragPayloadSize
+	if count == 0 {
+		count = 1
+	}
+	if uint64(len(data)) > 1<<32-1 {
+		return errors.New("[error] message length too long")
+	}
+	pid := uint32(os.Getpid())
+	seq := fragSeq.Add(1)
+	buf := make([]byte, q.maxSize)
+	for i := 0; i < count; i++ {
+		offset := i * fragPayloadSize
+		chunk := data[offset:min(offset+fragPayloadSize, len(data))]
+		binary.BigEndian.PutUint32(buf[0:], pid)
+		binary.BigEndian.PutUint64(buf[4:], seq)
+		binary.BigEndian.PutUint32(buf[12:], uint32(i))
+		binary.BigEndian.PutUint32(buf[16:], uint32(count))
+		binary.BigEndian.PutUint32(buf[20:], uint32(len(data)))
+		binary.BigEndian.PutUint32(buf[24:], uint32(offset))
+		n := copy(buf[fragHeaderSize:], chunk)
+		if err := q.Send(ctx, msgType, buf[:fragHeaderSize+n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fragKey struct {
+	pid uint32
+	seq uint64
+}
+
+type fragMessage struct {
+	data     []byte
+	seen     []bool
+	missing  int
+	lastSeen time.Time
+}
+
+// Reassembler ... Receives fragments sent by SendLarge and returns complete messages.
+// Messages that stay incomplete for longer than the timeout are dropped
+type Reassembler struct {
+	mu        sync.Mutex
+	q         *MessageQueue
+	timeout   time.Duration
+	pending   map[fragKey]*fragMessage
+	expired   uint64
+	truncated uint64
+}
+
+// NewReassembler ... Creates a Reassembler reading from q. A timeout <= 0 selects DefaultFragmentTimeout
+func NewReassembler(q *MessageQueue, timeout time.Duration) *Reassembler {
+	if timeout <= 0 {
+		timeout = DefaultFragmentTimeout
+	}
+	return &Reassembler{
+		q:       q,
+		timeout: timeout,
+		pending: make(map[fragKey]*fragMessage, 16),
+	}
+}
+
+// Receive ... Waits until a complete message of msgType has been reassembled or ctx is done.
+// Several goroutines may receive at once, each returns the messages its last fragment completes.
+// Fragments larger than the MaxSize of the queue handle, sent by a peer with a larger MaxSize,
+// are dropped rather than left in the queue; their message never completes
+func (r *Reassembler) Receive(ctx context.Context, msgType uint) ([]byte, error) {
+	opts := ReceiveOptions{Type: int64(msgType), NoError: true}
+	for {
+		// the queue read blocks, only the pending messages need the lock
+		frag, err := r.q.ReceiveWith(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		r.mu.Lock()
+		if frag.Truncated {
+			r.truncated++
+			r.mu.Unlock()
+			continue
+		}
+		data, err := r.add(frag.Data, time.Now())
+		r.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			return data, nil
+		}
+	}
+}
+
+// Pending ... Returns the number of incomplete messages being held
+func (r *Reassembler) Pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.pending)
+}
+
+// Expired ... Returns the number of incomplete messages dropped after the timeout
+func (r *Reassembler) Expired() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.expired
+}
+
+// Truncated ... Returns the number of fragments dropped for exceeding MaxSize
+func (r *Reassembler) Truncated() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.truncated
+}
+
+// add stores one fragment and returns the whole message once its last fragment arrived
+func (r *Reassembler) add(frag []byte, now time.Time) ([]byte, error) {
+	r.expire(now)
+	if len(frag) < fragHeaderSize {
+		return nil, ErrFragmentHeader
+	}
+	key := fragKey{
+		pid: binary.BigEndian.Uint32(frag[0:]),
+		seq: binary.BigEndian.Uint64(frag[4:]),
+	}
+	index := int(binary.BigEndian.Uint32(frag[12:]))
+	count := int(binary.BigEndian.Uint32(frag[16:]))
+	total := int(binary.BigEndian.Uint32(frag[20:]))
+	offset := int(binary.BigEndian.Uint32(frag[24:]))
+	payload := frag[fragHeaderSize:]
+	if count == 0 || index >= count || count > max(total, 1) || offset+len(payload) > total {
+		return nil, ErrFragmentHeader
+	}
+	if count == 1 {
+		return payload, nil
+	}
+
+	m, ok := r.pending[key]
+	if !ok {
+		m = &fragMessage{
+			data:    make([]byte, total),
+			seen:    make([]bool, count),
+			missing: count,
+		}
+		r.pending[key] = m
+	}
+	if len(m.data) != total || len(m.seen) != count {
+		delete(r.pending, key)
+		return nil, ErrFragmentHeader
+	}
+	m.lastSeen = now
+	if m.seen[index] {
+		return nil, nil
+	}
+	m.seen[index] = true
+	m.missing--
+	copy(m.data[offset:], payload)
+	if m.missing > 0 {
+		return nil, nil
+	}
+	delete(r.pending, key)
+	return m.data, nil
+}
+
+func (r *Reassembler) expire(now time.Time) {
+	for key, m := range r.pending {
+		if now.Sub(m.lastSeen) > r.timeout {
+			delete(r.pending, key)
+			r.expired++
+		}
+	}
+}
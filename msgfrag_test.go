@@ -0,0 +1,165 @@
+package ipc
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReassembler_Large(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	payloads := [][]byte{
+		{},
+		[]byte("small"),
+		bytes.Repeat([]byte("a"), msgSize-fragHeaderSize),
+		bytes.Repeat([]byte("0123456789"), 12*1024),
+	}
+
+	sent := make(chan error, 1)
+	go func() {
+		for _, p := range payloads {
+			if err := q.SendLarge(ctx, 1, p); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+
+	r := NewReassembler(q, time.Second)
+	for _, p := range payloads {
+		got, err := r.Receive(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, p, got)
+	}
+	require.Zero(t, r.Pending())
+	require.NoError(t, <-sent)
+}
+
+func TestReassembler_Concurrent(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	r := NewReassembler(q, time.Second)
+	const receivers = 4
+	results := make(chan []byte, receivers)
+	errs := make(chan error, receivers)
+	for i := 0; i < receivers; i++ {
+		go func() {
+			got, err := r.Receive(ctx, 1)
+			results <- got
+			errs <- err
+		}()
+	}
+
+	// the accessors do not wait for a fragment while receivers are blocked
+	done := make(chan int)
+	go func() { done <- r.Pending() }()
+	select {
+	case n := <-done:
+		require.Zero(t, n)
+	case <-time.After(time.Second):
+		t.Fatal("Pending blocked behind Receive")
+	}
+
+	payload := bytes.Repeat([]byte("0123456789"), 4*1024)
+	for i := 0; i < receivers; i++ {
+		require.NoError(t, q.SendLarge(ctx, 1, payload))
+	}
+	for i := 0; i < receivers; i++ {
+		require.NoError(t, <-errs)
+		require.Equal(t, payload, <-results)
+	}
+	require.Zero(t, r.Pending())
+}
+
+func TestReassembler_Interleaved(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	const senders = 4
+	errs := make([]error, senders)
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = q.SendLarge(ctx, 1, bytes.Repeat([]byte{byte('a' + i)}, 40*1024))
+		}(i)
+	}
+
+	r := NewReassembler(q, time.Second)
+	seen := make(map[byte]bool)
+	for i := 0; i < senders; i++ {
+		got, err := r.Receive(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, got, 40*1024)
+		require.Equal(t, bytes.Repeat(got[:1], len(got)), got)
+		seen[got[0]] = true
+	}
+	require.Len(t, seen, senders)
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestReassembler_Expire(t *testing.T) {
+	r := NewReassembler(nil, time.Second)
+	frag := make([]byte, fragHeaderSize+10)
+	frag[3] = 1                // pid
+	frag[11] = 1               // seq
+	frag[19] = 2               // count
+	frag[23] = byte(len(frag)) // total
+
+	now := time.Now()
+	data, err := r.add(frag, now)
+	require.NoError(t, err)
+	require.Nil(t, data)
+	require.Equal(t, 1, r.Pending())
+
+	_, err = r.add(frag[:4], now.Add(time.Second*2))
+	require.ErrorIs(t, err, ErrFragmentHeader)
+	require.Zero(t, r.Pending())
+	require.EqualValues(t, 1, r.Expired())
+}
+
+func TestReassembler_MaxSizeMismatch(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// the receiving handle accepts smaller messages than the sending one
+	small := &MessageQueue{id: q.ID(), maxSize: 256}
+	big := bytes.Repeat([]byte("x"), msgSize)
+	require.NoError(t, q.SendLarge(ctx, 1, big))
+	require.NoError(t, small.SendLarge(ctx, 1, []byte("fits")))
+
+	r := NewReassembler(small, time.Second)
+	got, err := r.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, "fits", string(got))
+	// the short tail fragment fits and waits for the timeout like any incomplete message
+	require.EqualValues(t, 1, r.Truncated())
+	require.Equal(t, 1, r.Pending())
+}
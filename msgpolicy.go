@@ -0,0 +1,147 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+)
+
+// SendPolicy ... Selects what SendWith does when the queue is full
+type SendPolicy int
+
+const (
+	// SendBlock waits for room until ctx is done, like Send
+	SendBlock SendPolicy = iota
+	// SendFailFast makes a single attempt and fails with ErrQueueFull
+	SendFailFast
+	// SendRetry retries with exponential backoff and fails with ErrQueueFull
+	// once SendOptions.Timeout has passed
+	SendRetry
+	// SendDropOldest discards the oldest message to make room and retries
+	SendDropOldest
+)
+
+// SendOptions ... Send policy and its parameters, the zero value blocks like Send
+type SendOptions struct {
+	Policy SendPolicy
+
+	// SendRetry: Backoff is the first delay, doubled after every attempt up to
+	// MaxBackoff. Timeout <= 0 retries until ctx is done
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Timeout    time.Duration
+
+	// SendDropOldest: type of the messages that may be discarded, 0 for any type
+	DropType uint
+}
+
+// SendWithRetry ... Returns options retrying for up to timeout
+func SendWithRetry(backoff, maxBackoff, timeout time.Duration) SendOptions {
+	return SendOptions{Policy: SendRetry, Backoff: backoff, MaxBackoff: maxBackoff, Timeout: timeout}
+}
+
+// SendWith ... Sends a message of the given type, handling a full queue as selected by opts.
+// SendRetry and SendDropOldest give up with ErrQueueFull, every policy returns ctx.Err()
+// once ctx is done. Dropping only discards messages of opts.DropType, when none are
+// left to discard it fails with ErrQueueFull
+func (q *MessageQueue) SendWith(ctx context.Context, msgType uint, data []byte, opts SendOptions) error {
+	switch opts.Policy {
+	case SendBlock:
+		return q.Send(ctx, msgType, data)
+	case SendFailFast:
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return sendMessage(q.id, msgType, data, q.maxSize, IPC_NOWAIT)
+	case SendRetry:
+		return q.sendRetry(ctx, msgType, data, opts)
+	case SendDropOldest:
+		return q.sendDropOldest(ctx, msgType, data, opts.DropType)
+	}
+	return errors.New("[error] unknown send policy")
+}
+
+func (q *MessageQueue) sendRetry(ctx context.Context, msgType uint, data []byte, opts SendOptions) error {
+	delay := opts.Backoff
+	if delay <= 0 {
+		delay = msgPollMin
+	}
+	maxDelay := opts.MaxBackoff
+	if maxDelay <= 0 {
+		maxDelay = msgPollMax
+	}
+	maxDelay = max(maxDelay, delay)
+	var deadline time.Time
+	if opts.Timeout > 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := sendMessage(q.id, msgType, data, q.maxSize, IPC_NOWAIT)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, ErrQueueFull), errors.Is(err, syscall.EINTR):
+		default:
+			return err
+		}
+		wait := delay
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return ErrQueueFull
+			}
+			wait = min(wait, left)
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+func (q *MessageQueue) sendDropOldest(ctx context.Context, msgType uint, data []byte, dropType uint) error {
+	var m *msgBuffer
+	defer func() {
+		if m != nil {
+			putMsgBuffer(m)
+		}
+	}()
+	nothingToDrop := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := sendMessage(q.id, msgType, data, q.maxSize, IPC_NOWAIT)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case !errors.Is(err, ErrQueueFull):
+			return err
+		case nothingToDrop:
+			return ErrQueueFull
+		}
+		if m == nil {
+			m = getMsgBuffer(q.maxSize)
+		}
+		// MSG_NOERROR discards messages longer than the handle's limit as well
+		_, err = receiveMsg(q.id, m.ptr(), q.maxSize, int64(dropType), IPC_NOWAIT|MSG_NOERROR)
+		switch {
+		case err == nil, errors.Is(err, syscall.EINTR):
+		case errors.Is(err, syscall.ENOMSG):
+			// a reader may have made room meanwhile, try once more
+			nothingToDrop = true
+		default:
+			return err
+		}
+	}
+}
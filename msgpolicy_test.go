@@ -0,0 +1,95 @@
+package ipc
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fillQueue sends 1KiB messages of type 1 with ascending first bytes until the queue is full
+func fillQueue(t *testing.T, q *MessageQueue) int {
+	n := 0
+	for {
+		msg := make([]byte, 1024)
+		msg[0] = byte(n)
+		err := SendMsg(q.ID(), 1, msg, IPC_NOWAIT)
+		if err != nil {
+			require.ErrorIs(t, err, ErrQueueFull)
+			require.ErrorIs(t, err, syscall.EAGAIN)
+			return n
+		}
+		n++
+	}
+}
+
+func TestMessageQueue_SendFailFast(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	opts := SendOptions{Policy: SendFailFast}
+	require.NoError(t, q.SendWith(context.Background(), 1, []byte(want), opts))
+	fillQueue(t, q)
+
+	start := time.Now()
+	err = q.SendWith(context.Background(), 1, make([]byte, 1024), opts)
+	require.ErrorIs(t, err, ErrQueueFull)
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestMessageQueue_SendRetry(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+	fillQueue(t, q)
+
+	opts := SendWithRetry(time.Millisecond, 10*time.Millisecond, 100*time.Millisecond)
+	start := time.Now()
+	err = q.SendWith(context.Background(), 1, make([]byte, 1024), opts)
+	require.ErrorIs(t, err, ErrQueueFull)
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	require.Less(t, time.Since(start), time.Second)
+
+	// a consumer catching up lets the retry through
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		ReceiveMsg(q.ID(), 0, IPC_NOWAIT)
+	}()
+	opts.Timeout = time.Second
+	require.NoError(t, q.SendWith(context.Background(), 2, make([]byte, 1024), opts))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	opts.Timeout = 0
+	err = q.SendWith(ctx, 1, make([]byte, 1024), opts)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMessageQueue_SendDropOldest(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+	n := fillQueue(t, q)
+
+	opts := SendOptions{Policy: SendDropOldest}
+	require.NoError(t, q.SendWith(context.Background(), 2, []byte(want), opts))
+
+	// the first message made room, the rest are still queued in order
+	ds, err := q.Stat()
+	require.NoError(t, err)
+	require.EqualValues(t, n, ds.Qnum)
+	got, err := ReceiveMsg(q.ID(), 1, IPC_NOWAIT)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, got[0])
+	got, err = ReceiveMsg(q.ID(), 2, IPC_NOWAIT)
+	require.NoError(t, err)
+	require.Equal(t, want, string(got))
+
+	// nothing of DropType to discard
+	fillQueue(t, q)
+	opts.DropType = 3
+	err = q.SendWith(context.Background(), 2, make([]byte, 1024), opts)
+	require.ErrorIs(t, err, ErrQueueFull)
+}
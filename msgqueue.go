@@ -0,0 +1,244 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// System V message queues cannot be waited on together with a Go channel,
+// so blocking operations are emulated by polling with IPC_NOWAIT and backing off
+// between attempts until the context is done.
+const (
+	msgPollMin = 50 * time.Microsecond
+	msgPollMax = 10 * time.Millisecond
+)
+
+// MessageQueue ... Handle to a System V message queue whose blocking operations
+// accept a context and return promptly on cancellation or deadline.
+// Messages are limited to MaxSize bytes and receive buffers are sized to match
+type MessageQueue struct {
+	id      int
+	key     uint64
+	maxSize int
+}
+
+// OpenMessageQueue ... Opens an existing message queue, failing with ENOENT if
+// no queue is associated with the key
+func OpenMessageQueue(key uint64) (*MessageQueue, error) {
+	return OpenMessageQueueSize(key, msgSize)
+}
+
+// OpenMessageQueueSize ... Like OpenMessageQueue for messages of up to maxSize bytes,
+// which may not exceed the kernel's msgmax
+func OpenMessageQueueSize(key uint64, maxSize int) (*MessageQueue, error) {
+	if err := checkMsgMaxSize(maxSize); err != nil {
+		return nil, err
+	}
+	id, err := GetMsg(key, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageQueue{id: id, key: key, maxSize: maxSize}, nil
+}
+
+// CreateMessageQueue ... Creates the message queue associated with the key if it
+// does not exist yet and opens it. perm holds the access permissions, e.g. IPC_RW.
+// With IPC_PRIVATE a new queue is always created
+func CreateMessageQueue(key uint64, perm int) (*MessageQueue, error) {
+	return CreateMessageQueueSize(key, perm, msgSize)
+}
+
+// CreateMessageQueueSize ... Like CreateMessageQueue for messages of up to maxSize bytes,
+// which may not exceed the kernel's msgmax
+func CreateMessageQueueSize(key uint64, perm int, maxSize int) (*MessageQueue, error) {
+	if err := checkMsgMaxSize(maxSize); err != nil {
+		return nil, err
+	}
+	id, err := GetMsg(key, IPC_CREAT|perm)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageQueue{id: id, key: key, maxSize: maxSize}, nil
+}
+
+// NewMessageQueue ... Wraps a message queue identifier obtained from GetMsg
+func NewMessageQueue(msgid int) *MessageQueue {
+	return &MessageQueue{id: msgid, maxSize: msgSize}
+}
+
+func checkMsgMaxSize(maxSize int) error {
+	if maxSize <= 0 {
+		return fmt.Errorf("[error] invalid max message size %d", maxSize)
+	}
+	if maxSize <= msgSize {
+		return nil
+	}
+	limit, err := MsgMax()
+	if err != nil {
+		return err
+	}
+	if maxSize > limit {
+		return fmt.Errorf("[error] max message size %d exceeds msgmax %d", maxSize, limit)
+	}
+	return nil
+}
+
+// ID ... Returns the message queue identifier
+func (q *MessageQueue) ID() int {
+	return q.id
+}
+
+// Key ... Returns the key the queue was opened with, 0 if unknown
+func (q *MessageQueue) Key() uint64 {
+	return q.key
+}
+
+// MaxSize ... Returns the largest message the handle sends or receives
+func (q *MessageQueue) MaxSize() int {
+	return q.maxSize
+}
+
+// Close ... Releases the handle. System V queues hold no per-process resources,
+// the queue itself lives on until Remove
+func (q *MessageQueue) Close() error {
+	return nil
+}
+
+// Remove ... Removes the message queue, waking up all blocked readers and writers
+func (q *MessageQueue) Remove() error {
+	return RemoveMsg(q.id)
+}
+
+// Stat ... Returns the kernel's view of the queue: depth, byte count,
+// last sender/receiver pid and timestamps
+func (q *MessageQueue) Stat() (*MsqidDs, error) {
+	ds := &MsqidDs{}
+	if err := Msgctl(q.id, IPC_STAT, ds); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// Set ... Applies the owner, permissions and qbytes limit of ds to the queue.
+// The usual pattern is to Stat, modify the returned structure and Set it back
+func (q *MessageQueue) Set(ds *MsqidDs) error {
+	return Msgctl(q.id, IPC_SET, ds)
+}
+
+// Send ... Sends a message of the given type, waiting while the queue is full
+// until there is room or ctx is done
+func (q *MessageQueue) Send(ctx context.Context, msgType uint, data []byte) error {
+	delay := msgPollMin
+	for {
+		err := sendMessage(q.id, msgType, data, q.maxSize, IPC_NOWAIT)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, ErrQueueFull), errors.Is(err, syscall.EINTR):
+		default:
+			return err
+		}
+		if err := pollWait(ctx, &delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Receive ... Receives the next message selected by msgType (see msgrcv(2)),
+// waiting until one arrives or ctx is done
+func (q *MessageQueue) Receive(ctx context.Context, msgType uint) ([]byte, error) {
+	_, data, err := q.receive(ctx, msgType)
+	return data, err
+}
+
+// receive is Receive that also reports the type of the received message
+func (q *MessageQueue) receive(ctx context.Context, msgType uint) (uint, []byte, error) {
+	delay := msgPollMin
+	for {
+		mtype, data, err := receiveMessage(q.id, msgType, q.maxSize, IPC_NOWAIT)
+		switch {
+		case err == nil:
+			return mtype, data, nil
+		case errors.Is(err, syscall.ENOMSG), errors.Is(err, syscall.EINTR):
+		default:
+			return 0, nil, err
+		}
+		if err := pollWait(ctx, &delay); err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+// ReceiveInto ... Receives the next message selected by msgType into buf, waiting until
+// one arrives or ctx is done, and returns its length and type. Messages longer than buf fail
+// with E2BIG. Unless it has to wait, it does not allocate
+func (q *MessageQueue) ReceiveInto(ctx context.Context, msgType uint, buf []byte) (int, uint, error) {
+	delay := msgPollMin
+	for {
+		n, mtype, err := ReceiveMsgInto(q.id, msgType, buf, IPC_NOWAIT)
+		switch {
+		case err == nil:
+			return n, mtype, nil
+		case errors.Is(err, syscall.ENOMSG), errors.Is(err, syscall.EINTR):
+		default:
+			return 0, 0, err
+		}
+		if err := pollWait(ctx, &delay); err != nil {
+			return 0, 0, err
+		}
+	}
+}
+
+// ReceiveWith ... Receives a message using the modes selected by opts, see ReceiveOptions.
+// Unless opts.NoWait or opts.Copy is set it waits until a message matches or ctx is done
+func (q *MessageQueue) ReceiveWith(ctx context.Context, opts ReceiveOptions) (*Received, error) {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = q.maxSize
+	}
+	if opts.NoWait || opts.Copy {
+		return ReceiveMsgWith(q.id, opts)
+	}
+	opts.NoWait = true
+	delay := msgPollMin
+	for {
+		r, err := ReceiveMsgWith(q.id, opts)
+		switch {
+		case err == nil:
+			return r, nil
+		case errors.Is(err, syscall.ENOMSG), errors.Is(err, syscall.EINTR):
+		default:
+			return nil, err
+		}
+		if err := pollWait(ctx, &delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Peek ... Returns a copy of the message at position index without removing it
+func (q *MessageQueue) Peek(index int) (*Received, error) {
+	opts := ReceivePeek(index)
+	opts.MaxSize = q.maxSize
+	return ReceiveMsgWith(q.id, opts)
+}
+
+// pollWait sleeps for *delay or until ctx is done and doubles *delay up to msgPollMax
+func pollWait(ctx context.Context, delay *time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t := time.NewTimer(*delay)
+	select {
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	case <-t.C:
+	}
+	if *delay *= 2; *delay > msgPollMax {
+		*delay = msgPollMax
+	}
+	return nil
+}
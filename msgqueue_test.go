@@ -0,0 +1,192 @@
+package ipc
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMessageQueue_SendAndReceive(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sent := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		sent <- q.Send(ctx, 2, []byte(want))
+	}()
+
+	got, err := q.Receive(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, want, string(got))
+	require.NoError(t, <-sent)
+}
+
+func TestMessageQueue_ReceiveCancel(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	_, err = q.Receive(ctx, 0)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestMessageQueue_SendCancel(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	big := make([]byte, msgSize)
+
+	// fill the queue until the kernel refuses more bytes
+	for SendMsg(q.ID(), 1, big, IPC_NOWAIT) == nil {
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		cancel()
+	}()
+	err = q.Send(ctx, 1, big)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMessageQueue_Open(t *testing.T) {
+	key, err := Ftok("msgqueue.go", 7)
+	require.NoError(t, err)
+
+	_, err = OpenMessageQueue(key)
+	require.Error(t, err)
+
+	q, err := CreateMessageQueue(key, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	q2, err := OpenMessageQueue(key)
+	require.NoError(t, err)
+	require.Equal(t, q.ID(), q2.ID())
+}
+
+func TestMessageQueue_Stat(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	require.NoError(t, q.Send(context.Background(), 1, []byte(want)))
+	ds, err := q.Stat()
+	require.NoError(t, err)
+	require.EqualValues(t, 1, ds.Qnum)
+
+	ds.Qbytes = 1 << 12
+	require.NoError(t, q.Set(ds))
+	ds, err = q.Stat()
+	require.NoError(t, err)
+	require.EqualValues(t, 1<<12, ds.Qbytes)
+}
+
+func TestMessageQueue_ReceiveWith(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sent := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		err := q.Send(ctx, 9, []byte("low"))
+		if err == nil {
+			err = q.Send(ctx, 4, []byte("high"))
+		}
+		sent <- err
+	}()
+	r, err := q.ReceiveWith(ctx, ReceiveExcept(1))
+	require.NoError(t, err)
+	require.EqualValues(t, 9, r.Mtype)
+	require.NoError(t, <-sent)
+
+	_, err = q.ReceiveWith(ctx, ReceiveExcept(4))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func BenchmarkMessageQueue_ReceiveInto(b *testing.B) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(b, err)
+	defer q.Remove()
+	ctx := context.Background()
+	payload := []byte(want)
+	buf := make([]byte, msgSize)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = SendMsg(q.ID(), 1, payload, IPC_NOWAIT)
+		if _, _, err := q.ReceiveInto(ctx, 0, buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestMessageQueue_MaxSize(t *testing.T) {
+	limit, err := MsgMax()
+	require.NoError(t, err)
+	_, err = CreateMessageQueueSize(IPC_PRIVATE, IPC_RW, limit+1)
+	require.Error(t, err)
+	_, err = CreateMessageQueueSize(IPC_PRIVATE, IPC_RW, 0)
+	require.Error(t, err)
+
+	q, err := CreateMessageQueueSize(IPC_PRIVATE, IPC_RW, 64)
+	require.NoError(t, err)
+	defer q.Remove()
+	require.Equal(t, 64, q.MaxSize())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.Error(t, q.Send(ctx, 1, make([]byte, 65)))
+	require.NoError(t, q.Send(ctx, 1, []byte(want[:64])))
+	got, err := q.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, want[:64], string(got))
+
+	// a larger message from another handle does not fit the receive buffer
+	require.NoError(t, NewMessageQueue(q.ID()).Send(ctx, 1, []byte(want)))
+	_, err = q.Receive(ctx, 1)
+	require.ErrorIs(t, err, syscall.E2BIG)
+
+	// fragments are sized to the queue, the reassembler adapts to any size
+	big := []byte(strings.Repeat(want, 8))
+	require.NoError(t, q.SendLarge(ctx, 2, big))
+	data, err := NewReassembler(NewMessageQueue(q.ID()), 0).Receive(ctx, 2)
+	require.NoError(t, err)
+	require.Equal(t, big, data)
+}
+
+func BenchmarkMessageQueue_SmallReceive(b *testing.B) {
+	q, err := CreateMessageQueueSize(IPC_PRIVATE, IPC_RW, 64)
+	require.NoError(b, err)
+	defer q.Remove()
+	ctx := context.Background()
+	payload := []byte(want[:64])
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = q.Send(ctx, 1, payload)
+		if _, err := q.Receive(ctx, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrPollerClosed = errors.New("[error] poller closed")
+
+// PollResult ... Message delivered by a Poller
+type PollResult struct {
+	Queue *MessageQueue
+	Index int  // position of the queue in the order it was added
+	Mtype uint // type of the received message
+	Data  []byte
+}
+
+type pollEntry struct {
+	q       *MessageQueue
+	msgType uint
+}
+
+// Poller ... Waits on several message queues at once and delivers the next message
+// from any of them. Queues are scanned round-robin starting after the one that
+// delivered last, so a busy queue cannot starve the others
+type Poller struct {
+	mu      sync.Mutex
+	entries []pollEntry
+	next    int
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewPoller() *Poller {
+	return &Poller{done: make(chan struct{})}
+}
+
+// Add ... Registers q, receiving messages selected by msgType (see msgrcv(2)),
+// and returns the index reported in PollResult
+func (p *Poller) Add(q *MessageQueue, msgType uint) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries = append(p.entries, pollEntry{q: q, msgType: msgType})
+	return len(p.entries) - 1
+}
+
+// Len ... Returns the number of registered queues
+func (p *Poller) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// Receive ... Waits until one of the queues has a message, ctx is done or the poller is closed
+func (p *Poller) Receive(ctx context.Context) (*PollResult, error) {
+	delay := msgPollMin
+	for {
+		res, err := p.poll()
+		if res != nil || err != nil {
+			return res, err
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-p.done:
+			t.Stop()
+			return nil, ErrPollerClosed
+		case <-t.C:
+		}
+		if delay *= 2; delay > msgPollMax {
+			delay = msgPollMax
+		}
+	}
+}
+
+// Close ... Wakes up all pending Receive calls with ErrPollerClosed, the queues are left untouched
+func (p *Poller) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.done)
+	})
+	return nil
+}
+
+// poll makes one non-blocking pass over all queues
+func (p *Poller) poll() (*PollResult, error) {
+	select {
+	case <-p.done:
+		return nil, ErrPollerClosed
+	default:
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.entries)
+	for i := 0; i < n; i++ {
+		idx := (p.next + i) % n
+		e := p.entries[idx]
+		mtype, data, err := receiveMessage(e.q.ID(), e.msgType, e.q.MaxSize(), IPC_NOWAIT)
+		switch {
+		case err == nil:
+			p.next = (idx + 1) % n
+			return &PollResult{Queue: e.q, Index: idx, Mtype: mtype, Data: data}, nil
+		case errors.Is(err, syscall.ENOMSG), errors.Is(err, syscall.EINTR):
+		default:
+			p.next = (idx + 1) % n
+			return nil, fmt.Errorf("queue %d: %w", idx, err)
+		}
+	}
+	return nil, nil
+}
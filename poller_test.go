@@ -0,0 +1,86 @@
+package ipc
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestPoller_Receive(t *testing.T) {
+	p := NewPoller()
+	defer p.Close()
+
+	queues := make([]*MessageQueue, 3)
+	for i := range queues {
+		q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+		require.NoError(t, err)
+		defer q.Remove()
+		queues[i] = q
+		require.Equal(t, i, p.Add(q, 0))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	sent := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		sent <- queues[2].Send(ctx, 7, []byte("late"))
+	}()
+	res, err := p.Receive(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, res.Index)
+	require.Equal(t, queues[2], res.Queue)
+	require.EqualValues(t, 7, res.Mtype)
+	require.Equal(t, "late", string(res.Data))
+	require.NoError(t, <-sent)
+}
+
+func TestPoller_Fairness(t *testing.T) {
+	p := NewPoller()
+	defer p.Close()
+
+	busy, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer busy.Remove()
+	quiet, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer quiet.Remove()
+	p.Add(busy, 0)
+	p.Add(quiet, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, busy.Send(ctx, 1, []byte("busy")))
+	}
+	require.NoError(t, quiet.Send(ctx, 1, []byte("quiet")))
+
+	var order []int
+	for i := 0; i < 3; i++ {
+		res, err := p.Receive(ctx)
+		require.NoError(t, err)
+		order = append(order, res.Index)
+	}
+	require.Equal(t, []int{0, 1, 0}, order)
+}
+
+func TestPoller_MsgTypeAndClose(t *testing.T) {
+	p := NewPoller()
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+	p.Add(q, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Send(ctx, 1, []byte("ignored")))
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		p.Close()
+	}()
+	_, err = p.Receive(ctx)
+	require.ErrorIs(t, err, ErrPollerClosed)
+}
@@ -0,0 +1,309 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Implementation of the POSIX message queue functions.
+// Queues are named objects living under /dev/mqueue (when mounted), each message
+// carries a priority and higher priorities are received first.
+// Descriptors are opened non-blocking and registered with the Go netpoller,
+// so a goroutine waiting for a message does not tie up an OS thread
+
+const (
+	/* sigev_notify values for `mq_notify'.  */
+	SIGEV_SIGNAL = 0 // notify via signal
+	SIGEV_NONE   = 1 // other notification: meaningless
+	SIGEV_THREAD = 2 // deliver via thread creation
+
+	mqPrioMax = 32768
+)
+
+// MqAttr ... Mirrors struct mq_attr
+type MqAttr struct {
+	Flags   int64 // O_NONBLOCK or 0, the only field mq_setattr changes
+	Maxmsg  int64 // max number of messages in the queue
+	Msgsize int64 // max message size in bytes
+	Curmsgs int64 // number of messages currently in the queue
+	_       [4]int64
+}
+
+// sigevent mirrors struct sigevent as used by mq_notify
+type sigevent struct {
+	Value  uintptr
+	Signo  int32
+	Notify int32
+	_      [48]byte
+}
+
+// MqOpen ... Opens, or with O_CREAT creates, the queue called name and returns its descriptor.
+// name may be given with or without the leading slash. attr sets maxmsg and msgsize
+// of a new queue, nil selects the system defaults
+func MqOpen(name string, oflag int, mode uint32, attr *MqAttr) (int, error) {
+	p, err := syscall.BytePtrFromString(strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return -1, err
+	}
+	fd, _, errno := syscall.Syscall6(syscall.SYS_MQ_OPEN, uintptr(unsafe.Pointer(p)), uintptr(oflag),
+		uintptr(mode), uintptr(unsafe.Pointer(attr)), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// MqUnlink ... Removes the queue name, it is destroyed once all descriptors are closed
+func MqUnlink(name string) error {
+	p, err := syscall.BytePtrFromString(strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MQ_UNLINK, uintptr(unsafe.Pointer(p)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// MqTimedsend ... Adds msg with priority prio to the queue.
+// A nil timeout blocks indefinitely unless the descriptor is non-blocking
+func MqTimedsend(mqd int, msg []byte, prio uint, timeout *syscall.Timespec) error {
+	var p unsafe.Pointer
+	if len(msg) > 0 {
+		p = unsafe.Pointer(&msg[0])
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_MQ_TIMEDSEND, uintptr(mqd), uintptr(p), uintptr(len(msg)),
+		uintptr(prio), uintptr(unsafe.Pointer(timeout)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// MqTimedreceive ... Removes the oldest message of the highest priority and copies it into buf,
+// which must be at least msgsize bytes long. It returns the message length and priority
+func MqTimedreceive(mqd int, buf []byte, timeout *syscall.Timespec) (int, uint, error) {
+	var prio uint32
+	var p unsafe.Pointer
+	if len(buf) > 0 {
+		p = unsafe.Pointer(&buf[0])
+	}
+	n, _, errno := syscall.Syscall6(syscall.SYS_MQ_TIMEDRECEIVE, uintptr(mqd), uintptr(p), uintptr(len(buf)),
+		uintptr(unsafe.Pointer(&prio)), uintptr(unsafe.Pointer(timeout)), 0)
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(n), uint(prio), nil
+}
+
+// MqNotify ... Asks for signal sig to be sent when a message arrives on the empty queue
+// and no process is blocked receiving. The registration fires once; sig 0 removes it
+func MqNotify(mqd int, sig syscall.Signal) error {
+	var sev *sigevent
+	if sig != 0 {
+		sev = &sigevent{Signo: int32(sig), Notify: SIGEV_SIGNAL}
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MQ_NOTIFY, uintptr(mqd), uintptr(unsafe.Pointer(sev)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// MqGetsetattr ... Applies newattr, if not nil, and returns the previous attributes
+func MqGetsetattr(mqd int, newattr *MqAttr) (*MqAttr, error) {
+	old := &MqAttr{}
+	_, _, errno := syscall.Syscall(syscall.SYS_MQ_GETSETATTR, uintptr(mqd), uintptr(unsafe.Pointer(newattr)),
+		uintptr(unsafe.Pointer(old)))
+	if errno != 0 {
+		return nil, errno
+	}
+	return old, nil
+}
+
+// PosixQueue ... Handle to a POSIX message queue, implements MsgQueue.
+// The msgType of Send is the message priority; Receive always returns the
+// highest priority message and only accepts msgType 0
+type PosixQueue struct {
+	name    string
+	file    *os.File
+	msgsize int
+
+	// serialize readers and writers, each direction owns its file deadline
+	rsem chan struct{}
+	wsem chan struct{}
+}
+
+// OpenPosixQueue ... Opens the queue called name. flag holds the access mode
+// (os.O_RDONLY, os.O_WRONLY or os.O_RDWR) optionally combined with os.O_CREAT and os.O_EXCL,
+// perm and attr are only used when the queue is created
+func OpenPosixQueue(name string, flag int, perm uint32, attr *MqAttr) (*PosixQueue, error) {
+	fd, err := MqOpen(name, flag|syscall.O_NONBLOCK|syscall.O_CLOEXEC, perm, attr)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := MqGetsetattr(fd, nil)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &PosixQueue{
+		name:    "/" + strings.TrimPrefix(name, "/"),
+		file:    os.NewFile(uintptr(fd), "/dev/mqueue"+"/"+strings.TrimPrefix(name, "/")),
+		msgsize: int(cur.Msgsize),
+		rsem:    make(chan struct{}, 1),
+		wsem:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Name ... Returns the queue name including the leading slash
+func (m *PosixQueue) Name() string {
+	return m.name
+}
+
+// Fd ... Returns the queue descriptor
+func (m *PosixQueue) Fd() int {
+	return int(m.file.Fd())
+}
+
+// Attr ... Returns the current queue attributes
+func (m *PosixQueue) Attr() (*MqAttr, error) {
+	var attr *MqAttr
+	err := m.control(func(fd uintptr) error {
+		var err error
+		attr, err = MqGetsetattr(int(fd), nil)
+		return err
+	})
+	return attr, err
+}
+
+// Notify ... Registers for signal sig on the next message arriving on the empty queue, 0 unregisters
+func (m *PosixQueue) Notify(sig syscall.Signal) error {
+	return m.control(func(fd uintptr) error {
+		return MqNotify(int(fd), sig)
+	})
+}
+
+// Send ... Sends data with priority prio, waiting while the queue is full until there is room or ctx is done
+func (m *PosixQueue) Send(ctx context.Context, prio uint, data []byte) error {
+	if prio >= mqPrioMax {
+		return syscall.EINVAL
+	}
+	return m.wait(ctx, m.wsem, true, func(fd uintptr) error {
+		return MqTimedsend(int(fd), data, prio, nil)
+	})
+}
+
+// Receive ... Receives the highest priority message, waiting until one arrives or ctx is done.
+// POSIX queues cannot select by type, a non-zero msgType fails with errors.ErrUnsupported
+func (m *PosixQueue) Receive(ctx context.Context, msgType uint) ([]byte, error) {
+	if msgType != 0 {
+		return nil, errors.ErrUnsupported
+	}
+	data, _, err := m.ReceivePriority(ctx)
+	return data, err
+}
+
+// ReceivePriority ... Like Receive, also returning the priority of the message
+func (m *PosixQueue) ReceivePriority(ctx context.Context) ([]byte, uint, error) {
+	buf := make([]byte, m.msgsize)
+	var n int
+	var prio uint
+	err := m.wait(ctx, m.rsem, false, func(fd uintptr) error {
+		var err error
+		n, prio, err = MqTimedreceive(int(fd), buf, nil)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return buf[:n], prio, nil
+}
+
+// Close ... Closes the descriptor, the queue itself stays until it is unlinked
+func (m *PosixQueue) Close() error {
+	return m.file.Close()
+}
+
+// Remove ... Unlinks the queue name and closes the descriptor
+func (m *PosixQueue) Remove() error {
+	err := MqUnlink(m.name)
+	if cerr := m.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (m *PosixQueue) control(fn func(fd uintptr) error) error {
+	rc, err := m.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	if err := rc.Control(func(fd uintptr) { opErr = fn(fd) }); err != nil {
+		return err
+	}
+	return opErr
+}
+
+// wait runs op until it stops failing with EAGAIN, parking on the netpoller in between.
+// Cancellation of ctx is turned into an expired file deadline to wake the poller
+func (m *PosixQueue) wait(ctx context.Context, sem chan struct{}, write bool, op func(fd uintptr) error) error {
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-sem }()
+
+	setDeadline := m.file.SetReadDeadline
+	if write {
+		setDeadline = m.file.SetWriteDeadline
+	}
+	deadline, _ := ctx.Deadline()
+	if err := setDeadline(deadline); err != nil {
+		return err
+	}
+	fired := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = setDeadline(time.Unix(1, 0))
+		close(fired)
+	})
+	defer func() {
+		// a late deadline must not leak into the next operation
+		if !stop() {
+			<-fired
+		}
+	}()
+
+	rc, err := m.file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	fn := func(fd uintptr) bool {
+		opErr = op(fd)
+		return !errors.Is(opErr, syscall.EAGAIN)
+	}
+	if write {
+		err = rc.Write(fn)
+	} else {
+		err = rc.Read(fn)
+	}
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return context.DeadlineExceeded
+	}
+	if err != nil {
+		return err
+	}
+	return opErr
+}
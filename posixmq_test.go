@@ -0,0 +1,113 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testMqName(t *testing.T) string {
+	return fmt.Sprintf("/ipc-%s-%d", t.Name(), os.Getpid())
+}
+
+func TestPosixQueue_Priority(t *testing.T) {
+	m, err := OpenPosixQueue(testMqName(t), os.O_RDWR|os.O_CREATE|os.O_EXCL, IPC_RW, &MqAttr{Maxmsg: 4, Msgsize: 64})
+	require.NoError(t, err)
+	defer m.Remove()
+
+	attr, err := m.Attr()
+	require.NoError(t, err)
+	require.EqualValues(t, 4, attr.Maxmsg)
+	require.EqualValues(t, 64, attr.Msgsize)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, m.Send(ctx, 1, []byte("low")))
+	require.NoError(t, m.Send(ctx, 9, []byte("high")))
+
+	attr, err = m.Attr()
+	require.NoError(t, err)
+	require.EqualValues(t, 2, attr.Curmsgs)
+
+	data, prio, err := m.ReceivePriority(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "high", string(data))
+	require.EqualValues(t, 9, prio)
+
+	_, err = m.Receive(ctx, 3)
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestPosixQueue_Blocking(t *testing.T) {
+	m, err := OpenPosixQueue(testMqName(t), os.O_RDWR|os.O_CREATE, IPC_RW, &MqAttr{Maxmsg: 1, Msgsize: 16})
+	require.NoError(t, err)
+	defer m.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err = m.Receive(ctx, 0)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	sent := make(chan error, 1)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		sent <- m.Send(ctx, 0, []byte("wake"))
+	}()
+	got, err := m.Receive(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, "wake", string(got))
+	require.NoError(t, <-sent)
+
+	// queue is full after one message, the second send waits until cancelled
+	require.NoError(t, m.Send(ctx, 0, []byte("one")))
+	sctx, scancel := context.WithCancel(ctx)
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		scancel()
+	}()
+	require.ErrorIs(t, m.Send(sctx, 0, []byte("two")), context.Canceled)
+}
+
+func TestPosixQueue_Notify(t *testing.T) {
+	m, err := OpenPosixQueue(testMqName(t), os.O_RDWR|os.O_CREATE, IPC_RW, nil)
+	require.NoError(t, err)
+	defer m.Remove()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	defer signal.Stop(sigs)
+
+	require.NoError(t, m.Notify(syscall.SIGUSR1))
+	require.NoError(t, m.Send(context.Background(), 1, []byte("ping")))
+	select {
+	case sig := <-sigs:
+		require.Equal(t, syscall.SIGUSR1, sig)
+	case <-time.After(time.Second):
+		t.Fatal("no notification")
+	}
+}
+
+func TestMsgQueue_Backends(t *testing.T) {
+	sysv, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	posix, err := OpenPosixQueue(testMqName(t), os.O_RDWR|os.O_CREATE, IPC_RW, nil)
+	require.NoError(t, err)
+
+	for _, q := range []MsgQueue{sysv, posix} {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		require.NoError(t, q.Send(ctx, 1, []byte(want)))
+		got, err := q.Receive(ctx, 0)
+		require.NoError(t, err)
+		require.Equal(t, want, string(got))
+		cancel()
+		require.NoError(t, q.Remove())
+	}
+}
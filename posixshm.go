@@ -0,0 +1,101 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// Implementation of POSIX shared memory, the shm_open(3) way: objects are files
+// under /dev/shm, sized with ftruncate and mapped with mmap. They show up in the
+// file system and live until unlinked or until the next reboot
+
+const posixShmDir = "/dev/shm"
+
+// posixShmPath maps name, with or without the leading slash, to its file under /dev/shm
+func posixShmPath(name string) (string, error) {
+	base := strings.TrimPrefix(name, "/")
+	if base == "" || strings.Contains(base, "/") || base == "." || base == ".." {
+		return "", fmt.Errorf("[error] invalid POSIX shared memory name %q", name)
+	}
+	return posixShmDir + "/" + base, nil
+}
+
+// OpenPosixShm ... Opens, or with O_CREATE creates, the shared memory object called name
+// and maps it. flag holds the access mode (os.O_RDONLY or os.O_RDWR) optionally combined with
+// os.O_CREATE and os.O_EXCL, perm is only used when the object is created.
+// A size > 0 grows a smaller object to size bytes; objects are never shrunk, because processes
+// still mapping the cut off part would fault. Size 0 maps the object at its current size
+func OpenPosixShm(name string, flag int, perm uint32, size int64) (*Segment, error) {
+	path, err := posixShmPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) == os.O_WRONLY {
+		return nil, errors.New("[error] shared memory cannot be mapped write-only")
+	}
+	f, err := os.OpenFile(path, flag|syscall.O_NOFOLLOW, os.FileMode(perm))
+	if err != nil {
+		return nil, err
+	}
+	// the mapping stays valid after the descriptor is closed
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if size > st.Size() {
+		if err := f.Truncate(size); err != nil {
+			return nil, err
+		}
+	} else {
+		size = st.Size()
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("[error] shared memory object %s is empty", name)
+	}
+
+	readOnly := flag&(os.O_WRONLY|os.O_RDWR) == os.O_RDONLY
+	prot := syscall.PROT_READ
+	if !readOnly {
+		prot |= syscall.PROT_WRITE
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), prot, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &Segment{
+		id:       -1,
+		name:     "/" + strings.TrimPrefix(name, "/"),
+		data:     data,
+		readOnly: readOnly,
+		detach:   syscall.Munmap,
+		remove: func() error {
+			return os.Remove(path)
+		},
+	}, nil
+}
+
+// UnlinkPosixShm ... Removes the name of the shared memory object, the memory is freed
+// once every process unmapped it
+func UnlinkPosixShm(name string) error {
+	path, err := posixShmPath(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// TruncatePosixShm ... Changes the size of the shared memory object called name.
+// Processes mapping the object keep their mapping size; accessing pages beyond a
+// shrunk end raises SIGBUS
+func TruncatePosixShm(name string, size int64) error {
+	path, err := posixShmPath(name)
+	if err != nil {
+		return err
+	}
+	return os.Truncate(path, size)
+}
@@ -0,0 +1,86 @@
+package ipc
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestPosixShm_ReadWrite(t *testing.T) {
+	name := testMqName(t)
+	seg, err := OpenPosixShm(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, IPC_RW, 4096)
+	require.NoError(t, err)
+	defer seg.Remove()
+	require.Equal(t, name, seg.Name())
+	require.Equal(t, -1, seg.ID())
+	require.EqualValues(t, 4096, seg.Size())
+
+	_, err = os.Stat(posixShmDir + name)
+	require.NoError(t, err)
+
+	_, err = seg.WriteAt([]byte(want), 100)
+	require.NoError(t, err)
+
+	// a second mapping, the way another process would open it
+	reader, err := OpenPosixShm(name, os.O_RDONLY, 0, 0)
+	require.NoError(t, err)
+	defer reader.Close()
+	require.EqualValues(t, 4096, reader.Size())
+	got := make([]byte, len(want))
+	_, err = reader.ReadAt(got, 100)
+	require.NoError(t, err)
+	require.Equal(t, want, string(got))
+	_, err = reader.WriteAt(got, 0)
+	require.ErrorIs(t, err, ErrSegmentReadOnly)
+	_, err = reader.Stat()
+	require.ErrorIs(t, err, errors.ErrUnsupported)
+
+	// the sequence lock layout works on either backend
+	require.NoError(t, seg.WriteSeq([]byte("seq")))
+	v, err := reader.ReadSeq()
+	require.NoError(t, err)
+	require.Equal(t, "seq", string(v))
+
+	_, err = OpenPosixShm(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, IPC_RW, 4096)
+	require.ErrorIs(t, err, os.ErrExist)
+}
+
+func TestPosixShm_Size(t *testing.T) {
+	name := testMqName(t)
+	_, err := OpenPosixShm(name, os.O_RDWR|os.O_CREATE, IPC_RW, 0)
+	require.Error(t, err)
+	defer UnlinkPosixShm(name)
+
+	seg, err := OpenPosixShm(name, os.O_RDWR, 0, 100)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, seg.Size())
+	require.NoError(t, seg.Close())
+
+	// grows, never shrinks
+	seg, err = OpenPosixShm(name, os.O_RDWR, 0, 50)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, seg.Size())
+	require.NoError(t, seg.Close())
+
+	require.NoError(t, TruncatePosixShm(name, 8192))
+	seg, err = OpenPosixShm(name, os.O_RDWR, 0, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+	_, err = seg.Seek(-1, io.SeekEnd)
+	require.NoError(t, err)
+	_, err = seg.Write([]byte("xy"))
+	require.ErrorIs(t, err, ErrSegmentBounds)
+	require.EqualValues(t, 8192, seg.Size())
+
+	require.NoError(t, UnlinkPosixShm(name))
+	_, err = OpenPosixShm(name, os.O_RDWR, 0, 0)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	// the mapping outlives the name
+	_, err = seg.WriteAt([]byte("z"), 0)
+	require.NoError(t, err)
+
+	_, err = OpenPosixShm("/a/b", os.O_RDWR, 0, 0)
+	require.Error(t, err)
+}
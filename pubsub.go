@@ -0,0 +1,365 @@
+package ipc
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Topic based publish/subscribe over System V message queues.
+// Every subscription owns a private queue. Subscriptions are recorded in a
+// registry file, so independent processes using the same path discover each other.
+// Updates are serialized by flock on "<path>.lock" and replace the registry atomically,
+// a crash mid-update leaves the previous registry intact. Topics are dot separated tokens;
+// in patterns "*" matches exactly one token and a trailing ">" matches one or more tokens.
+//
+// Message: | topic len uint16 | topic | payload ... |
+
+const pubsubMsgType = 1
+
+var ErrTopicMalformed = errors.New("[error] malformed pubsub message")
+
+type subscriberEntry struct {
+	Queue    int      `json:"queue"`
+	Pid      int      `json:"pid"`
+	PidNS    string   `json:"pidns,omitempty"`
+	Patterns []string `json:"patterns"`
+}
+
+type subscriberRegistry struct {
+	Subscribers []subscriberEntry `json:"subscribers"`
+}
+
+// PubSub ... Handle to a subscriber registry shared by publishers and subscribers
+type PubSub struct {
+	path string
+	lock Lock
+}
+
+// NewPubSub ... Opens the registry at path, creating an empty one if needed
+func NewPubSub(path string) (*PubSub, error) {
+	for _, name := range []string{path, path + ".lock"} {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		f.Close()
+	}
+	// the registry is replaced on every update, so the lock lives in a file of its own
+	lock, err := NewLock(path+".lock", FlockMode)
+	if err != nil {
+		return nil, err
+	}
+	return &PubSub{path: path, lock: lock}, nil
+}
+
+// Close ... Releases the registry lock, subscriptions stay registered
+func (p *PubSub) Close() error {
+	p.lock.Close()
+	return nil
+}
+
+// Subscribe ... Registers a new subscription receiving every topic matched by one of patterns
+func (p *PubSub) Subscribe(patterns ...string) (*Subscription, error) {
+	if len(patterns) == 0 {
+		return nil, errors.New("[error] no topic pattern")
+	}
+	for _, pattern := range patterns {
+		if err := validatePattern(pattern); err != nil {
+			return nil, err
+		}
+	}
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	if err != nil {
+		return nil, err
+	}
+	err = p.update(func(reg *subscriberRegistry) {
+		reg.Subscribers = append(reg.Subscribers, subscriberEntry{
+			Queue:    q.ID(),
+			Pid:      os.Getpid(),
+			PidNS:    pidNamespace(),
+			Patterns: patterns,
+		})
+	})
+	if err != nil {
+		q.Remove()
+		return nil, err
+	}
+	return &Subscription{ps: p, q: q}, nil
+}
+
+// PublishError ... Reports the subscriptions a message could not be delivered to,
+// keyed by queue id. It unwraps to the individual errors, e.g. ErrQueueFull
+type PublishError struct {
+	Topic  string
+	Failed map[int]error
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("publish %s: %d subscriptions failed: %v", e.Topic, len(e.Failed), errors.Join(e.Unwrap()...))
+}
+
+func (e *PublishError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Publish ... Sends data to every subscription matching topic and returns how many received it.
+// A subscription whose queue is full is skipped with ErrQueueFull, see PublishWith
+func (p *PubSub) Publish(ctx context.Context, topic string, data []byte) (int, error) {
+	return p.PublishWith(ctx, topic, data, SendOptions{Policy: SendFailFast})
+}
+
+// PublishWith ... Sends data to every subscription matching topic, handling a full queue as
+// selected by opts, and returns how many received it. The subscriptions are sent to concurrently,
+// so a slow subscriber never delays the others. Failed subscriptions are reported by a
+// *PublishError, subscriptions whose process or queue is gone are pruned from the registry
+func (p *PubSub) PublishWith(ctx context.Context, topic string, data []byte, opts SendOptions) (int, error) {
+	if len(topic) == 0 || len(topic) > 1<<16-1 {
+		return 0, fmt.Errorf("[error] invalid topic length: %d", len(topic))
+	}
+	msg := make([]byte, 2+len(topic)+len(data))
+	binary.BigEndian.PutUint16(msg, uint16(len(topic)))
+	copy(msg[2+copy(msg[2:], topic):], data)
+
+	p.lock.RLock()
+	reg, err := p.read()
+	p.lock.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		failed = make(map[int]error)
+		dead   = make(map[int]bool)
+	)
+	delivered := 0
+	for _, s := range reg.Subscribers {
+		if !s.matches(topic) {
+			continue
+		}
+		if !s.alive() {
+			dead[s.Queue] = true
+			continue
+		}
+		wg.Add(1)
+		go func(queue int) {
+			defer wg.Done()
+			err := NewMessageQueue(queue).SendWith(ctx, pubsubMsgType, msg, opts)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				delivered++
+			case errors.Is(err, syscall.EINVAL), errors.Is(err, syscall.EIDRM):
+				dead[queue] = true
+			default:
+				failed[queue] = err
+			}
+		}(s.Queue)
+	}
+	wg.Wait()
+
+	if len(dead) > 0 {
+		err = p.remove(dead)
+	}
+	if len(failed) > 0 {
+		pubErr := &PublishError{Topic: topic, Failed: failed}
+		if err == nil {
+			return delivered, pubErr
+		}
+		err = errors.Join(err, pubErr)
+	}
+	return delivered, err
+}
+
+// Prune ... Drops subscriptions whose process exited or whose queue was removed
+// and returns how many were dropped
+func (p *PubSub) Prune() (int, error) {
+	p.lock.RLock()
+	reg, err := p.read()
+	p.lock.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	dead := make(map[int]bool)
+	for _, s := range reg.Subscribers {
+		if !s.alive() || Msgctl(s.Queue, IPC_STAT, &MsqidDs{}) != nil {
+			dead[s.Queue] = true
+		}
+	}
+	if len(dead) == 0 {
+		return 0, nil
+	}
+	return len(dead), p.remove(dead)
+}
+
+// remove unregisters the given queues and removes those that still exist
+func (p *PubSub) remove(queues map[int]bool) error {
+	err := p.update(func(reg *subscriberRegistry) {
+		alive := reg.Subscribers[:0]
+		for _, s := range reg.Subscribers {
+			if !queues[s.Queue] {
+				alive = append(alive, s)
+			}
+		}
+		reg.Subscribers = alive
+	})
+	for id := range queues {
+		_ = RemoveMsg(id)
+	}
+	return err
+}
+
+func (p *PubSub) update(fn func(reg *subscriberRegistry)) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	reg, err := p.read()
+	if err != nil {
+		return err
+	}
+	fn(reg)
+	data, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return p.write(data)
+}
+
+// write replaces the registry with data through a temporary file, so readers
+// and a crash at any point see either the old or the new registry
+func (p *PubSub) write(data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(p.path), filepath.Base(p.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (p *PubSub) read() (*subscriberRegistry, error) {
+	reg := &subscriberRegistry{}
+	data, err := os.ReadFile(p.path)
+	if err != nil || len(data) == 0 {
+		return reg, err
+	}
+	if err := json.Unmarshal(data, reg); err != nil {
+		return nil, fmt.Errorf("corrupt pubsub registry %s: %w", p.path, err)
+	}
+	return reg, nil
+}
+
+// Subscription ... Receiving end of a subscription
+type Subscription struct {
+	ps *PubSub
+	q  *MessageQueue
+}
+
+// Receive ... Waits for the next published message or until ctx is done
+func (s *Subscription) Receive(ctx context.Context) (string, []byte, error) {
+	msg, err := s.q.Receive(ctx, pubsubMsgType)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(msg) < 2 {
+		return "", nil, ErrTopicMalformed
+	}
+	n := 2 + int(binary.BigEndian.Uint16(msg))
+	if len(msg) < n {
+		return "", nil, ErrTopicMalformed
+	}
+	return string(msg[2:n]), msg[n:], nil
+}
+
+// Close ... Unregisters the subscription and removes its queue
+func (s *Subscription) Close() error {
+	return s.ps.remove(map[int]bool{s.q.ID(): true})
+}
+
+// alive reports whether the subscribing process may still exist. Pids are only
+// comparable within one pid namespace: entries written from another namespace are
+// kept as long as their queue exists, which Publish and Prune check separately
+func (s *subscriberEntry) alive() bool {
+	if s.PidNS != "" && s.PidNS != pidNamespace() {
+		return true
+	}
+	return processAlive(s.Pid)
+}
+
+func (s *subscriberEntry) matches(topic string) bool {
+	for _, pattern := range s.Patterns {
+		if MatchTopic(pattern, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchTopic ... Reports whether topic matches pattern, see PubSub for the wildcards
+func MatchTopic(pattern, topic string) bool {
+	pt := strings.Split(pattern, ".")
+	tt := strings.Split(topic, ".")
+	for i, p := range pt {
+		if p == ">" && i == len(pt)-1 {
+			return len(tt) > i
+		}
+		if i >= len(tt) || (p != "*" && p != tt[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(tt)
+}
+
+func validatePattern(pattern string) error {
+	tokens := strings.Split(pattern, ".")
+	for i, t := range tokens {
+		if t == "" || (t == ">" && i != len(tokens)-1) {
+			return fmt.Errorf("[error] invalid topic pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// pidNamespace identifies the pid namespace of this process, empty if unknown
+var pidNamespace = sync.OnceValue(func() string {
+	ns, _ := os.Readlink("/proc/self/ns/pid")
+	return ns
+})
+
+// pidNamespaceID is pidNamespace as the inode number of the namespace, 0 if unknown
+func pidNamespaceID() uint64 {
+	var id uint64
+	_, _ = fmt.Sscanf(pidNamespace(), "pid:[%d]", &id)
+	return id
+}
+
+// processAlive reports whether a process with the given pid exists in this pid namespace.
+// A pid recorded by a process in another namespace names an unrelated process here, or none
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,218 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	for _, c := range []struct {
+		pattern, topic string
+		match          bool
+	}{
+		{"a.b.c", "a.b.c", true},
+		{"a.b.c", "a.b", false},
+		{"a.*.c", "a.x.c", true},
+		{"a.*.c", "a.x.y.c", false},
+		{"a.>", "a.b", true},
+		{"a.>", "a.b.c", true},
+		{"a.>", "a", false},
+		{"*", "a", true},
+		{"*", "a.b", false},
+	} {
+		require.Equal(t, c.match, MatchTopic(c.pattern, c.topic), "%s ~ %s", c.pattern, c.topic)
+	}
+}
+
+func TestPubSub_Publish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry")
+	pub, err := NewPubSub(path)
+	require.NoError(t, err)
+	defer pub.Close()
+
+	// subscribers discover the publisher only through the registry file
+	sub, err := NewPubSub(path)
+	require.NoError(t, err)
+	defer sub.Close()
+
+	prices, err := sub.Subscribe("md.*.price")
+	require.NoError(t, err)
+	defer prices.Close()
+	all, err := sub.Subscribe("md.>", "md.*.price")
+	require.NoError(t, err)
+	defer all.Close()
+
+	_, err = sub.Subscribe("a.>.b")
+	require.Error(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	n, err := pub.Publish(ctx, "md.eur.price", []byte("1.08"))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	n, err = pub.Publish(ctx, "md.eur.volume", []byte("100"))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	topic, data, err := prices.Receive(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "md.eur.price", topic)
+	require.Equal(t, "1.08", string(data))
+
+	for _, want := range []string{"md.eur.price", "md.eur.volume"} {
+		topic, _, err := all.Receive(ctx)
+		require.NoError(t, err)
+		require.Equal(t, want, topic)
+	}
+
+	// unsubscribed queues no longer receive
+	require.NoError(t, prices.Close())
+	n, err = pub.Publish(ctx, "md.usd.price", nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+}
+
+func TestPubSub_Prune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry")
+	ps, err := NewPubSub(path)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	gone, err := ps.Subscribe("x")
+	require.NoError(t, err)
+	require.NoError(t, gone.q.Remove())
+
+	live, err := ps.Subscribe("x")
+	require.NoError(t, err)
+	defer live.Close()
+
+	// a subscriber whose process died
+	orphan, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	require.NoError(t, ps.update(func(reg *subscriberRegistry) {
+		reg.Subscribers = append(reg.Subscribers, subscriberEntry{Queue: orphan.ID(), Pid: 1 << 30, Patterns: []string{"y"}})
+	}))
+
+	n, err := ps.Prune()
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	_, err = orphan.Stat()
+	require.Error(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err = ps.Publish(ctx, "x", []byte("hi"))
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), `"y"`)
+}
+
+func TestPubSub_AtomicUpdate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "registry")
+	ps, err := NewPubSub(path)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	sub, err := ps.Subscribe("x")
+	require.NoError(t, err)
+	defer sub.Close()
+
+	// a writer killed before its rename leaves a stray temp file, not a truncated registry
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "registry.123.tmp"), []byte(`{"subscri`), 0600))
+	other, err := NewPubSub(path)
+	require.NoError(t, err)
+	defer other.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	n, err := other.Publish(ctx, "x", nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	require.ElementsMatch(t, []string{"registry", "registry.lock", "registry.123.tmp"}, names)
+}
+
+func TestPubSub_PidNamespace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry")
+	ps, err := NewPubSub(path)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	// the pid of a subscriber in another namespace means nothing here
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	require.NoError(t, ps.update(func(reg *subscriberRegistry) {
+		reg.Subscribers = append(reg.Subscribers, subscriberEntry{
+			Queue: q.ID(), Pid: 1 << 30, PidNS: "pid:[1]", Patterns: []string{"x"},
+		})
+	}))
+	n, err := ps.Prune()
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	// it goes once its queue does
+	require.NoError(t, q.Remove())
+	n, err = ps.Prune()
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+}
+
+func TestPubSub_FullSubscriber(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry")
+	ps, err := NewPubSub(path)
+	require.NoError(t, err)
+	defer ps.Close()
+
+	full, err := ps.Subscribe("x")
+	require.NoError(t, err)
+	defer full.Close()
+	live, err := ps.Subscribe("x")
+	require.NoError(t, err)
+	defer live.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for {
+		err := full.q.SendWith(ctx, 99, make([]byte, 1024), SendOptions{Policy: SendFailFast})
+		if errors.Is(err, ErrQueueFull) {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	n, err := ps.Publish(ctx, "x", []byte("hi"))
+	require.Equal(t, 1, n)
+	var pubErr *PublishError
+	require.ErrorAs(t, err, &pubErr)
+	require.Len(t, pubErr.Failed, 1)
+	require.ErrorIs(t, pubErr.Failed[full.q.ID()], ErrQueueFull)
+	require.ErrorIs(t, err, ErrQueueFull)
+
+	// a blocking policy waits for the full subscriber without delaying the other
+	short, cancelShort := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancelShort()
+	n, err = ps.PublishWith(short, "x", []byte("again"), SendOptions{})
+	require.Equal(t, 1, n)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	for _, want := range []string{"hi", "again"} {
+		_, data, err := live.Receive(ctx)
+		require.NoError(t, err)
+		require.Equal(t, want, string(data))
+	}
+}
@@ -0,0 +1,41 @@
+package ipc
+
+import "context"
+
+// Queue ... Message queue exchanging values of type T encoded by a Codec.
+// Encoding failures are returned as *CodecError, everything else comes from the transport
+type Queue[T any] struct {
+	q     *MessageQueue
+	codec Codec
+}
+
+func NewQueue[T any](q *MessageQueue, codec Codec) *Queue[T] {
+	return &Queue[T]{q: q, codec: codec}
+}
+
+// MessageQueue ... Returns the underlying message queue
+func (q *Queue[T]) MessageQueue() *MessageQueue {
+	return q.q
+}
+
+// Send ... Encodes v and sends it with msgType, waiting while the queue is full
+func (q *Queue[T]) Send(ctx context.Context, msgType uint, v T) error {
+	data, err := q.codec.Marshal(&v)
+	if err != nil {
+		return &CodecError{Op: "marshal", Err: err}
+	}
+	return q.q.Send(ctx, msgType, data)
+}
+
+// Receive ... Waits for the next message selected by msgType and decodes it
+func (q *Queue[T]) Receive(ctx context.Context, msgType uint) (T, error) {
+	var v T
+	data, err := q.q.Receive(ctx, msgType)
+	if err != nil {
+		return v, err
+	}
+	if err := q.codec.Unmarshal(data, &v); err != nil {
+		return v, &CodecError{Op: "unmarshal", Err: err}
+	}
+	return v, nil
+}
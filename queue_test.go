@@ -0,0 +1,59 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type queueEvent struct {
+	Name  string
+	Count int
+}
+
+func TestQueue_SendAndReceive(t *testing.T) {
+	mq, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer mq.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, c := range []Codec{GobCodec{}, JSONCodec{}} {
+		q := NewQueue[queueEvent](mq, c)
+		require.NoError(t, q.Send(ctx, 1, queueEvent{Name: "a", Count: 3}))
+		got, err := q.Receive(ctx, 1)
+		require.NoError(t, err)
+		require.Equal(t, queueEvent{Name: "a", Count: 3}, got)
+	}
+
+	points := NewQueue[codecPoint](mq, BinaryCodec{})
+	require.NoError(t, points.Send(ctx, 1, codecPoint{X: 7}))
+	p, err := points.Receive(ctx, 1)
+	require.NoError(t, err)
+	require.EqualValues(t, 7, p.X)
+}
+
+func TestQueue_CodecError(t *testing.T) {
+	mq, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer mq.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, mq.Send(ctx, 1, []byte("not json")))
+	_, err = NewQueue[queueEvent](mq, JSONCodec{}).Receive(ctx, 1)
+	var codecErr *CodecError
+	require.ErrorAs(t, err, &codecErr)
+	require.Equal(t, "unmarshal", codecErr.Op)
+
+	err = NewQueue[queueEvent](mq, BinaryCodec{}).Send(ctx, 1, queueEvent{})
+	require.ErrorAs(t, err, &codecErr)
+
+	_, err = NewQueue[queueEvent](mq, JSONCodec{}).Receive(ctx, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.False(t, errors.As(err, &codecErr))
+}
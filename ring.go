@@ -0,0 +1,277 @@
+package ipc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Single-producer/single-consumer ring buffer in a System V shared memory segment.
+// The segment starts with a segment header, written once by the creator, and two cache lines,
+// so the producer and consumer never write to the same line, followed by the data area:
+//
+//	| segment header | head uint64 | ... | tail uint64 | ... | data ... |
+//
+// The capacity of the data area is the header capacity less the two cache lines.
+// head and tail are running byte counts, the consumer only advances head and the producer
+// only advances tail, so neither needs a lock. Records are framed as
+// | length uint32 | payload | in native byte order and wrap around the end of the data area.
+
+const (
+	cacheLineSize  = 64
+	ringHeaderSize = SegmentHeaderSize + 2*cacheLineSize
+	ringRecordSize = 4
+	ringVersion    = 1
+	ringTypeName   = "ipc.RingBuffer"
+
+	// how long CreateRingBuffer waits for a racing creator to write the header
+	ringInitTimeout = time.Second
+)
+
+var (
+	ErrRingFull  = errors.New("[error] ring buffer full")
+	ErrRingEmpty = errors.New("[error] ring buffer empty")
+	ErrRingShort = errors.New("[error] ring buffer record truncated")
+)
+
+// RingBuffer ... Handle to one side of a shared memory ring buffer. Any number of
+// processes may attach, but at any time only one may send and only one may receive
+type RingBuffer struct {
+	seg      *Segment
+	capacity uint64
+	head     *atomic.Uint64 // consumer position
+	tail     *atomic.Uint64 // producer position
+	data     []byte
+}
+
+// CreateRingBuffer ... Creates the ring buffer associated with the key if it does not exist
+// yet and attaches it. capacity is the size of the data area in bytes and must be a power of two.
+// An existing segment is never written to, it must hold a ring buffer of the same capacity.
+// perm holds the access permissions, e.g. IPC_RW
+func CreateRingBuffer(key uint64, capacity int, perm int) (*RingBuffer, error) {
+	if capacity < cacheLineSize || capacity&(capacity-1) != 0 {
+		return nil, fmt.Errorf("[error] ring capacity %d is not a power of two >= %d", capacity, cacheLineSize)
+	}
+	s := NewShm()
+	id, err := s.Shmget(key, uint64(ringHeaderSize+capacity), IPC_CREAT|IPC_EXCL|perm)
+	if errors.Is(err, syscall.EEXIST) {
+		return openRingBuffer(key, capacity)
+	}
+	if err != nil {
+		return nil, err
+	}
+	seg, err := s.Attach(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	// a fresh segment is zeroed, so head and tail start at 0
+	_, err = WriteSegmentHeader(seg, ringVersion, ringTypeName)
+	if err != nil {
+		seg.Close()
+		return nil, err
+	}
+	r, err := newRingBuffer(seg)
+	if err != nil {
+		seg.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// openRingBuffer attaches the ring buffer a racing creator set up under key and checks its
+// capacity, waiting up to ringInitTimeout for the creator to write the header
+func openRingBuffer(key uint64, capacity int) (*RingBuffer, error) {
+	s := NewShm()
+	id, err := s.Shmget(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	seg, err := s.Attach(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ringInitTimeout)
+	defer cancel()
+	delay := msgPollMin
+	for {
+		r, err := newRingBuffer(seg)
+		if err == nil && r.capacity != uint64(capacity) {
+			err = fmt.Errorf("%w: ring capacity %d, want %d", ErrSegmentCapacity, r.capacity, capacity)
+		}
+		if err == nil {
+			return r, nil
+		}
+		// the header is missing or half written until the creator is done
+		if !errors.Is(err, ErrSegmentMagic) && !errors.Is(err, ErrSegmentChecksum) || pollWait(ctx, &delay) != nil {
+			seg.Close()
+			return nil, err
+		}
+	}
+}
+
+// OpenRingBuffer ... Attaches the existing ring buffer associated with the key
+func OpenRingBuffer(key uint64) (*RingBuffer, error) {
+	s := NewShm()
+	id, err := s.Shmget(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	seg, err := s.Attach(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRingBuffer(seg)
+	if err != nil {
+		seg.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func newRingBuffer(seg *Segment) (*RingBuffer, error) {
+	h, err := CheckSegmentHeader(seg, ringVersion, ringTypeName)
+	if err != nil {
+		return nil, fmt.Errorf("segment %d is not a ring buffer: %w", seg.ID(), err)
+	}
+	capacity := h.Capacity - 2*cacheLineSize
+	if h.Capacity < 2*cacheLineSize+cacheLineSize || capacity&(capacity-1) != 0 {
+		return nil, fmt.Errorf("%w: segment %d: invalid ring capacity %d", ErrSegmentCapacity, seg.ID(), h.Capacity)
+	}
+	mem := seg.Bytes()
+	return &RingBuffer{
+		seg:      seg,
+		capacity: capacity,
+		head:     (*atomic.Uint64)(unsafe.Pointer(&mem[SegmentHeaderSize])),
+		tail:     (*atomic.Uint64)(unsafe.Pointer(&mem[SegmentHeaderSize+cacheLineSize])),
+		data:     mem[ringHeaderSize : ringHeaderSize+capacity],
+	}, nil
+}
+
+// ID ... Returns the identifier of the underlying segment
+func (r *RingBuffer) ID() int {
+	return r.seg.ID()
+}
+
+// Cap ... Returns the size of the data area in bytes
+func (r *RingBuffer) Cap() int {
+	return int(r.capacity)
+}
+
+// Len ... Returns the number of bytes in use, including the record framing
+func (r *RingBuffer) Len() int {
+	head := r.head.Load()
+	return int(r.tail.Load() - head)
+}
+
+// Empty ... Reports whether there is no record to receive
+func (r *RingBuffer) Empty() bool {
+	return r.Len() == 0
+}
+
+// Full ... Reports whether not even an empty record fits
+func (r *RingBuffer) Full() bool {
+	return r.Cap()-r.Len() < ringRecordSize
+}
+
+// TrySend ... Appends one record, failing with ErrRingFull if there is no room for it
+func (r *RingBuffer) TrySend(data []byte) error {
+	need := uint64(ringRecordSize + len(data))
+	if need > r.capacity {
+		return fmt.Errorf("[error] record of %d bytes exceeds ring capacity %d", len(data), r.capacity)
+	}
+	tail := r.tail.Load()
+	if r.capacity-(tail-r.head.Load()) < need {
+		return ErrRingFull
+	}
+	var length [ringRecordSize]byte
+	binary.NativeEndian.PutUint32(length[:], uint32(len(data)))
+	r.put(tail, length[:])
+	r.put(tail+ringRecordSize, data)
+	r.tail.Store(tail + need)
+	return nil
+}
+
+// TryReceive ... Removes the oldest record, failing with ErrRingEmpty if there is none
+func (r *RingBuffer) TryReceive() ([]byte, error) {
+	head := r.head.Load()
+	used := r.tail.Load() - head
+	if used == 0 {
+		return nil, ErrRingEmpty
+	}
+	var length [ringRecordSize]byte
+	r.get(head, length[:])
+	n := uint64(binary.NativeEndian.Uint32(length[:]))
+	if used < ringRecordSize || n > used-ringRecordSize {
+		return nil, ErrRingShort
+	}
+	data := make([]byte, n)
+	r.get(head+ringRecordSize, data)
+	r.head.Store(head + ringRecordSize + n)
+	return data, nil
+}
+
+// Send ... Appends one record, waiting while the ring is full until there is room or ctx is done
+func (r *RingBuffer) Send(ctx context.Context, data []byte) error {
+	spins, delay := 0, msgPollMin
+	for {
+		err := r.TrySend(data)
+		if !errors.Is(err, ErrRingFull) {
+			return err
+		}
+		if err := ringWait(ctx, &spins, &delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Receive ... Removes the oldest record, waiting until one arrives or ctx is done
+func (r *RingBuffer) Receive(ctx context.Context) ([]byte, error) {
+	spins, delay := 0, msgPollMin
+	for {
+		data, err := r.TryReceive()
+		if !errors.Is(err, ErrRingEmpty) {
+			return data, err
+		}
+		if err := ringWait(ctx, &spins, &delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Close ... Detaches the ring buffer, it stays in place until Remove
+func (r *RingBuffer) Close() error {
+	return r.seg.Close()
+}
+
+// Remove ... Marks the segment for removal once every process detached and detaches it
+func (r *RingBuffer) Remove() error {
+	return r.seg.Remove()
+}
+
+// put copies p into the data area at running position pos, wrapping around the end
+func (r *RingBuffer) put(pos uint64, p []byte) {
+	n := copy(r.data[pos&(r.capacity-1):], p)
+	copy(r.data, p[n:])
+}
+
+// get copies from the data area at running position pos into p, wrapping around the end
+func (r *RingBuffer) get(pos uint64, p []byte) {
+	n := copy(p, r.data[pos&(r.capacity-1):])
+	copy(p[n:], r.data)
+}
+
+// ringWait spins briefly for low latency before falling back to pollWait
+func ringWait(ctx context.Context, spins *int, delay *time.Duration) error {
+	if *spins < 128 {
+		*spins++
+		runtime.Gosched()
+		return ctx.Err()
+	}
+	return pollWait(ctx, delay)
+}
@@ -0,0 +1,148 @@
+package ipc
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRingBuffer_SendAndReceive(t *testing.T) {
+	key, err := Ftok("test/a", 19)
+	require.NoError(t, err)
+	producer, err := CreateRingBuffer(key, 256, IPC_RW)
+	require.NoError(t, err)
+	defer producer.Remove()
+
+	consumer, err := OpenRingBuffer(key)
+	require.NoError(t, err)
+	defer consumer.Close()
+	require.Equal(t, 256, consumer.Cap())
+	require.True(t, consumer.Empty())
+
+	_, err = consumer.TryReceive()
+	require.ErrorIs(t, err, ErrRingEmpty)
+
+	// records of 4+60 bytes wrap around the 256 byte data area
+	for i := 0; i < 20; i++ {
+		msg := make([]byte, 60)
+		msg[0], msg[59] = byte(i), byte(i)
+		require.NoError(t, producer.TrySend(msg))
+		if i%3 == 2 {
+			for j := 0; j < 3; j++ {
+				got, err := consumer.TryReceive()
+				require.NoError(t, err)
+				require.Len(t, got, 60)
+				require.Equal(t, byte(i-2+j), got[0])
+				require.Equal(t, byte(i-2+j), got[59])
+			}
+		}
+	}
+	require.Equal(t, 2*64, consumer.Len())
+
+	require.NoError(t, producer.TrySend(make([]byte, 60)))
+	require.NoError(t, producer.TrySend(make([]byte, 60)))
+	require.ErrorIs(t, producer.TrySend(nil), ErrRingFull)
+	require.True(t, producer.Full())
+	require.Error(t, producer.TrySend(make([]byte, 256)))
+
+	// capacity must match an existing ring
+	_, err = CreateRingBuffer(key, 512, IPC_RW)
+	require.Error(t, err)
+	_, err = CreateRingBuffer(key, 100, IPC_RW)
+	require.Error(t, err)
+}
+
+func TestRingBuffer_Stream(t *testing.T) {
+	producer, err := CreateRingBuffer(IPC_PRIVATE, 1024, IPC_RW)
+	require.NoError(t, err)
+	defer producer.Remove()
+
+	seg, err := NewShm().Attach(producer.ID(), 0)
+	require.NoError(t, err)
+	consumer, err := newRingBuffer(seg)
+	require.NoError(t, err)
+	defer consumer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 20000
+	go func() {
+		for i := 0; i < n; i++ {
+			msg := make([]byte, 8+i%100)
+			binary.LittleEndian.PutUint64(msg, uint64(i))
+			if err := producer.Send(ctx, msg); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		got, err := consumer.Receive(ctx)
+		require.NoError(t, err)
+		require.Len(t, got, 8+i%100)
+		require.EqualValues(t, i, binary.LittleEndian.Uint64(got))
+	}
+
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	_, err = consumer.Receive(short)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestRingBuffer_NotARing(t *testing.T) {
+	s, id := testSegment(t, 1024)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+	_, err = newRingBuffer(seg)
+	require.Error(t, err)
+}
+
+func TestRingBuffer_CreateExisting(t *testing.T) {
+	key, err := Ftok("test/b", 19)
+	require.NoError(t, err)
+
+	// a segment that is not a ring is left untouched
+	s := NewShm()
+	id, err := s.Shmget(key, ringHeaderSize+256, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	copy(seg.Bytes(), "not a ring")
+	_, err = CreateRingBuffer(key, 256, IPC_RW)
+	require.ErrorIs(t, err, ErrSegmentMagic)
+	require.Equal(t, "not a ring", string(seg.Bytes()[:10]))
+	require.NoError(t, seg.Remove())
+
+	// racing creators all attach the ring the first one set up
+	const creators = 4
+	rings := make([]*RingBuffer, creators)
+	errs := make([]error, creators)
+	var wg sync.WaitGroup
+	for i := range rings {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rings[i], errs[i] = CreateRingBuffer(key, 256, IPC_RW)
+		}(i)
+	}
+	wg.Wait()
+	for i, r := range rings {
+		require.NoError(t, errs[i])
+		require.Equal(t, rings[0].ID(), r.ID())
+		require.Equal(t, 256, r.Cap())
+	}
+	require.NoError(t, rings[0].TrySend([]byte("ring")))
+	got, err := rings[creators-1].TryReceive()
+	require.NoError(t, err)
+	require.Equal(t, "ring", string(got))
+	for _, r := range rings[1:] {
+		r.Close()
+	}
+	require.NoError(t, rings[0].Remove())
+}
@@ -0,0 +1,360 @@
+package ipc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Request/response RPC over a System V message queue.
+// Requests are sent with mtype RPCRequestType, replies with the mtype the caller
+// put into the request (derived from its pid by default), so one queue serves many clients.
+// A reply that arrives after its call timed out, or after its client closed, is orphaned: the
+// client drops it by id while running and drains its address on Close, and call ids start at the
+// client creation time so a leftover reply never matches a later client's call.
+// Payloads go through SendLarge/Reassembler and are not limited by msgSize.
+//
+// Request:  | kind=1 uint8 | id uint64 | replyTo uint64 | method len uint16 | method | payload ... |
+// Response: | kind=2 uint8 | id uint64 | error len uint32 | error | payload ... |
+
+const (
+	// RPCRequestType ... mtype used for requests, never a valid reply address
+	RPCRequestType = 1
+
+	rpcRequest  = 1
+	rpcResponse = 2
+
+	// default reply addresses keep the pid + RPCRequestType in the low bits (pid_max is 2^22)
+	// and a per-process client number above, bounded so the mtype stays a positive long
+	rpcReplySeqShift = 23
+	rpcReplySeqMax   = math.MaxInt >> rpcReplySeqShift
+)
+
+var (
+	rpcReplySeq   atomic.Uint64
+	rpcReplyMu    sync.Mutex
+	rpcReplyAddrs = make(map[uint]struct{}, 16)
+)
+
+var (
+	ErrRPCClosed    = errors.New("[error] rpc client closed")
+	ErrRPCMalformed = errors.New("[error] malformed rpc message")
+)
+
+// RPCError ... Error returned by a remote handler
+type RPCError struct {
+	Method  string
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc %s: %s", e.Method, e.Message)
+}
+
+// RPCHandler ... Serves one method call, a returned error is propagated to the caller as *RPCError
+type RPCHandler func(ctx context.Context, req []byte) ([]byte, error)
+
+// RPCServer ... Dispatches requests read from a queue to registered handlers
+type RPCServer struct {
+	q        *MessageQueue
+	mu       sync.RWMutex
+	handlers map[string]RPCHandler
+}
+
+func NewRPCServer(q *MessageQueue) *RPCServer {
+	return &RPCServer{
+		q:        q,
+		handlers: make(map[string]RPCHandler, 16),
+	}
+}
+
+// Handle ... Registers the handler for method, replacing any previous one
+func (s *RPCServer) Handle(method string, h RPCHandler) {
+	s.mu.Lock()
+	s.handlers[method] = h
+	s.mu.Unlock()
+}
+
+// Serve ... Reads requests until ctx is done, running each handler in its own goroutine.
+// It waits for in-flight handlers before returning ctx.Err()
+func (s *RPCServer) Serve(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	r := NewReassembler(s.q, 0)
+	for {
+		msg, err := r.Receive(ctx, RPCRequestType)
+		if err != nil {
+			if errors.Is(err, ErrFragmentHeader) {
+				continue
+			}
+			return err
+		}
+		id, replyTo, method, req, err := decodeRPCRequest(msg)
+		if err != nil {
+			continue
+		}
+		s.mu.RLock()
+		h := s.handlers[method]
+		s.mu.RUnlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var resp []byte
+			var err error
+			if h == nil {
+				err = fmt.Errorf("unknown method %q", method)
+			} else {
+				resp, err = h(ctx, req)
+			}
+			_ = s.q.SendLarge(ctx, uint(replyTo), encodeRPCResponse(id, err, resp))
+		}()
+	}
+}
+
+type rpcReply struct {
+	resp []byte
+	err  string
+	ok   bool
+}
+
+// RPCClient ... Issues calls to an RPCServer, any number of calls may be in flight at once
+type RPCClient struct {
+	q       *MessageQueue
+	replyTo uint
+	nextID  atomic.Uint64
+
+	mu     sync.Mutex
+	calls  map[uint64]chan rpcReply
+	closed bool
+
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRPCClient ... Creates a client receiving replies with mtype replyTo, 0 derives a free
+// address from the process id and a per-process client number, so pid 1 in a container and
+// several clients in one process all get distinct addresses.
+// Clients sharing a queue must use distinct reply addresses, an address already used by
+// another client of this process is rejected
+func NewRPCClient(q *MessageQueue, replyTo uint) (*RPCClient, error) {
+	if replyTo == RPCRequestType {
+		return nil, fmt.Errorf("[error] reply address %d is reserved for requests", replyTo)
+	}
+	if replyTo == 0 {
+		replyTo = claimRPCDefaultReplyTo(os.Getpid())
+		if replyTo == 0 {
+			return nil, errors.New("[error] no free rpc reply address in this process")
+		}
+	} else if !claimRPCReplyTo(replyTo) {
+		return nil, fmt.Errorf("[error] reply address %d already in use in this process", replyTo)
+	}
+	// replies left at the address by an earlier client are stale
+	drainRPCReplies(q, replyTo)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &RPCClient{
+		q:       q,
+		replyTo: replyTo,
+		calls:   make(map[uint64]chan rpcReply, 16),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	c.nextID.Store(uint64(time.Now().UnixNano()))
+	go c.dispatch(ctx)
+	return c, nil
+}
+
+// rpcPidReplyTo returns the default reply address of the n-th client of process pid,
+// always past RPCRequestType
+func rpcPidReplyTo(pid int, n uint64) uint {
+	return uint(n&rpcReplySeqMax)<<rpcReplySeqShift + uint(pid) + RPCRequestType
+}
+
+// claimRPCDefaultReplyTo claims the next free default address of process pid, 0 if all are taken
+func claimRPCDefaultReplyTo(pid int) uint {
+	for i := 0; i <= rpcReplySeqMax; i++ {
+		replyTo := rpcPidReplyTo(pid, rpcReplySeq.Add(1)-1)
+		if claimRPCReplyTo(replyTo) {
+			return replyTo
+		}
+	}
+	return 0
+}
+
+func claimRPCReplyTo(replyTo uint) bool {
+	rpcReplyMu.Lock()
+	defer rpcReplyMu.Unlock()
+	if _, ok := rpcReplyAddrs[replyTo]; ok {
+		return false
+	}
+	rpcReplyAddrs[replyTo] = struct{}{}
+	return true
+}
+
+func releaseRPCReplyTo(replyTo uint) {
+	rpcReplyMu.Lock()
+	delete(rpcReplyAddrs, replyTo)
+	rpcReplyMu.Unlock()
+}
+
+// drainRPCReplies discards the replies queued for replyTo without waiting
+func drainRPCReplies(q *MessageQueue, replyTo uint) {
+	opts := ReceiveOptions{Type: int64(replyTo), NoWait: true, NoError: true}
+	for {
+		if _, err := q.ReceiveWith(context.Background(), opts); err != nil {
+			return
+		}
+	}
+}
+
+// Call ... Invokes method with req and waits for the reply or for ctx to be done,
+// the ctx deadline acts as the per-call timeout
+func (c *RPCClient) Call(ctx context.Context, method string, req []byte) ([]byte, error) {
+	if len(method) > 1<<16-1 {
+		return nil, fmt.Errorf("[error] method name too long: %d", len(method))
+	}
+	id := c.nextID.Add(1)
+	ch := make(chan rpcReply, 1)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrRPCClosed
+	}
+	c.calls[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.calls, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.q.SendLarge(ctx, RPCRequestType, encodeRPCRequest(id, uint64(c.replyTo), method, req)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case reply := <-ch:
+		if !reply.ok {
+			return nil, ErrRPCClosed
+		}
+		if reply.err != "" {
+			return nil, &RPCError{Method: method, Message: reply.err}
+		}
+		return reply.resp, nil
+	}
+}
+
+// Close ... Stops receiving replies and fails all calls still in flight with ErrRPCClosed.
+// Replies already queued for the client are discarded and its address is released
+func (c *RPCClient) Close() error {
+	c.cancel()
+	<-c.done
+	c.closeOnce.Do(func() {
+		drainRPCReplies(c.q, c.replyTo)
+		releaseRPCReplyTo(c.replyTo)
+	})
+	return nil
+}
+
+func (c *RPCClient) dispatch(ctx context.Context) {
+	defer func() {
+		c.mu.Lock()
+		c.closed = true
+		for _, ch := range c.calls {
+			select {
+			case ch <- rpcReply{}:
+			default:
+			}
+		}
+		c.mu.Unlock()
+		close(c.done)
+	}()
+
+	r := NewReassembler(c.q, 0)
+	for {
+		msg, err := r.Receive(ctx, c.replyTo)
+		if err != nil {
+			if errors.Is(err, ErrFragmentHeader) {
+				continue
+			}
+			return
+		}
+		id, errMsg, resp, err := decodeRPCResponse(msg)
+		if err != nil {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.calls[id]
+		c.mu.Unlock()
+		if ok {
+			select {
+			case ch <- rpcReply{resp: resp, err: errMsg, ok: true}:
+			default:
+			}
+		}
+	}
+}
+
+func encodeRPCRequest(id, replyTo uint64, method string, req []byte) []byte {
+	buf := make([]byte, 19+len(method)+len(req))
+	buf[0] = rpcRequest
+	binary.BigEndian.PutUint64(buf[1:], id)
+	binary.BigEndian.PutUint64(buf[9:], replyTo)
+	binary.BigEndian.PutUint16(buf[17:], uint16(len(method)))
+	n := 19 + copy(buf[19:], method)
+	copy(buf[n:], req)
+	return buf
+}
+
+func decodeRPCRequest(buf []byte) (id, replyTo uint64, method string, req []byte, err error) {
+	if len(buf) < 19 || buf[0] != rpcRequest {
+		return 0, 0, "", nil, ErrRPCMalformed
+	}
+	id = binary.BigEndian.Uint64(buf[1:])
+	replyTo = binary.BigEndian.Uint64(buf[9:])
+	n := 19 + int(binary.BigEndian.Uint16(buf[17:]))
+	if len(buf) < n || replyTo == RPCRequestType {
+		return 0, 0, "", nil, ErrRPCMalformed
+	}
+	return id, replyTo, string(buf[19:n]), buf[n:], nil
+}
+
+func encodeRPCResponse(id uint64, err error, resp []byte) []byte {
+	var errMsg string
+	if err != nil {
+		errMsg = err.Error()
+		resp = nil
+	}
+	buf := make([]byte, 13+len(errMsg)+len(resp))
+	buf[0] = rpcResponse
+	binary.BigEndian.PutUint64(buf[1:], id)
+	binary.BigEndian.PutUint32(buf[9:], uint32(len(errMsg)))
+	n := 13 + copy(buf[13:], errMsg)
+	copy(buf[n:], resp)
+	return buf
+}
+
+func decodeRPCResponse(buf []byte) (id uint64, errMsg string, resp []byte, err error) {
+	if len(buf) < 13 || buf[0] != rpcResponse {
+		return 0, "", nil, ErrRPCMalformed
+	}
+	id = binary.BigEndian.Uint64(buf[1:])
+	n := 13 + int(binary.BigEndian.Uint32(buf[9:]))
+	if len(buf) < n {
+		return 0, "", nil, ErrRPCMalformed
+	}
+	return id, string(buf[13:n]), buf[n:], nil
+}
@@ -0,0 +1,193 @@
+package ipc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestRPC(t *testing.T) (*RPCClient, context.CancelFunc) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+
+	s := NewRPCServer(q)
+	s.Handle("echo", func(ctx context.Context, req []byte) ([]byte, error) {
+		return req, nil
+	})
+	s.Handle("fail", func(ctx context.Context, req []byte) ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	s.Handle("sleep", func(ctx context.Context, req []byte) ([]byte, error) {
+		time.Sleep(time.Millisecond * 200)
+		return req, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.Serve(ctx)
+	}()
+
+	c, err := NewRPCClient(q, 0)
+	require.NoError(t, err)
+	return c, func() {
+		cancel()
+		<-served
+		c.Close()
+		q.Remove()
+	}
+}
+
+func TestRPC_Call(t *testing.T) {
+	c, stop := newTestRPC(t)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	require.EqualValues(t, os.Getpid()+RPCRequestType, c.replyTo&(1<<rpcReplySeqShift-1))
+	got, err := c.Call(ctx, "echo", []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(got))
+
+	big := bytes.Repeat([]byte("x"), 64*1024)
+	got, err = c.Call(ctx, "echo", big)
+	require.NoError(t, err)
+	require.Equal(t, big, got)
+
+	_, err = c.Call(ctx, "fail", nil)
+	var rpcErr *RPCError
+	require.ErrorAs(t, err, &rpcErr)
+	require.Equal(t, "boom", rpcErr.Message)
+
+	_, err = c.Call(ctx, "missing", nil)
+	require.ErrorAs(t, err, &rpcErr)
+}
+
+func TestRPC_Concurrent(t *testing.T) {
+	c, stop := newTestRPC(t)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	const calls = 16
+	results := make([][]byte, calls)
+	errs := make([]error, calls)
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = c.Call(ctx, "echo", []byte(fmt.Sprintf("call-%d", i)))
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < calls; i++ {
+		require.NoError(t, errs[i])
+		require.Equal(t, fmt.Sprintf("call-%d", i), string(results[i]))
+	}
+}
+
+func TestRPC_Timeout(t *testing.T) {
+	c, stop := newTestRPC(t)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err := c.Call(ctx, "sleep", nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	c.Close()
+	_, err = c.Call(context.Background(), "echo", nil)
+	require.ErrorIs(t, err, ErrRPCClosed)
+}
+
+func TestRPC_ReplyTo(t *testing.T) {
+	// pid 1, as in most containers, must not collide with the request type
+	require.NotEqualValues(t, RPCRequestType, rpcPidReplyTo(1, 0))
+	require.EqualValues(t, 2, rpcPidReplyTo(1, 0))
+	// the highest pid does not spill into the client number
+	require.NotEqual(t, rpcPidReplyTo(1<<22, 0), rpcPidReplyTo(0, 1))
+
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+	_, err = NewRPCClient(q, RPCRequestType)
+	require.Error(t, err)
+
+	// default clients of one process get distinct addresses
+	c1, err := NewRPCClient(q, 0)
+	require.NoError(t, err)
+	defer c1.Close()
+	c2, err := NewRPCClient(q, 0)
+	require.NoError(t, err)
+	defer c2.Close()
+	require.NotEqual(t, c1.replyTo, c2.replyTo)
+
+	_, err = NewRPCClient(q, c1.replyTo)
+	require.Error(t, err)
+}
+
+func TestRPC_OrphanedReply(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	c, err := NewRPCClient(q, 1000)
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	// a late reply for the closed client is drained by the next client at its address
+	require.NoError(t, q.SendLarge(ctx, 1000, encodeRPCResponse(1, nil, []byte("stale"))))
+
+	c, err = NewRPCClient(q, 1000)
+	require.NoError(t, err)
+	defer c.Close()
+	_, err = q.ReceiveWith(ctx, ReceiveOptions{Type: 1000, NoWait: true})
+	require.ErrorIs(t, err, syscall.ENOMSG)
+	require.Greater(t, c.nextID.Load(), uint64(1))
+}
+
+func TestRPC_MaxSizeMismatch(t *testing.T) {
+	q, err := CreateMessageQueue(IPC_PRIVATE, IPC_RW)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// the server handle accepts smaller messages than the client one
+	s := NewRPCServer(&MessageQueue{id: q.ID(), maxSize: 256})
+	s.Handle("echo", func(ctx context.Context, req []byte) ([]byte, error) {
+		return req, nil
+	})
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ctx) }()
+
+	c, err := NewRPCClient(q, 0)
+	require.NoError(t, err)
+	defer c.Close()
+
+	short, cancelShort := context.WithTimeout(ctx, time.Millisecond*200)
+	defer cancelShort()
+	_, err = c.Call(short, "echo", bytes.Repeat([]byte("x"), msgSize))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	got, err := c.Call(ctx, "echo", []byte("hello"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(got))
+
+	cancel()
+	require.ErrorIs(t, <-served, context.Canceled)
+}
@@ -0,0 +1,165 @@
+package ipc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+)
+
+// Optional header at the start of a segment describing its contents, all integers are BigEndian:
+//
+//	| magic uint32 | header format uint8 | reserved uint8 | layout version uint16 | capacity uint64 |
+//	| type name len uint16 | reserved uint16 | crc32 uint32 | type name, zero padded ... |
+//
+// The layout version and type name are chosen by the creator, capacity is the number of bytes
+// following the header. crc32 (IEEE) covers the header with the crc field set to zero; the
+// contents after the header change while the segment is in use and are not covered.
+
+const (
+	SegmentHeaderSize = 128
+
+	segHeaderMagic     = 0x49504353 // "IPCS"
+	segHeaderFormat    = 1
+	segHeaderCRCOffset = 20
+	segHeaderNameMax   = SegmentHeaderSize - 24
+)
+
+var (
+	ErrSegmentMagic    = errors.New("[error] segment has no header")
+	ErrSegmentFormat   = errors.New("[error] unsupported segment header format")
+	ErrSegmentVersion  = errors.New("[error] segment layout version mismatch")
+	ErrSegmentType     = errors.New("[error] segment type mismatch")
+	ErrSegmentChecksum = errors.New("[error] segment header checksum mismatch")
+	ErrSegmentCapacity = errors.New("[error] segment capacity mismatch")
+)
+
+// SegmentHeader ... Contents of a segment header
+type SegmentHeader struct {
+	Version  uint16 // layout version chosen by the creator
+	TypeName string // Go type, or any name, describing the contents
+	Capacity uint64 // bytes following the header
+}
+
+// WriteSegmentHeader ... Writes a header for version and typeName to the start of seg,
+// the rest of the segment is its capacity
+func WriteSegmentHeader(seg *Segment, version uint16, typeName string) (*SegmentHeader, error) {
+	if len(typeName) > segHeaderNameMax {
+		return nil, fmt.Errorf("[error] type name too long: %d > %d", len(typeName), segHeaderNameMax)
+	}
+	size := seg.Size()
+	if size < SegmentHeaderSize {
+		return nil, fmt.Errorf("[error] segment of %d bytes too small for a header", size)
+	}
+	h := &SegmentHeader{Version: version, TypeName: typeName, Capacity: uint64(size - SegmentHeaderSize)}
+	buf := make([]byte, SegmentHeaderSize)
+	binary.BigEndian.PutUint32(buf[0:], segHeaderMagic)
+	buf[4] = segHeaderFormat
+	binary.BigEndian.PutUint16(buf[6:], h.Version)
+	binary.BigEndian.PutUint64(buf[8:], h.Capacity)
+	binary.BigEndian.PutUint16(buf[16:], uint16(len(typeName)))
+	copy(buf[24:], typeName)
+	binary.BigEndian.PutUint32(buf[segHeaderCRCOffset:], crc32.ChecksumIEEE(buf))
+	if _, err := seg.WriteAt(buf, 0); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ReadSegmentHeader ... Reads and verifies the header at the start of seg
+func ReadSegmentHeader(seg *Segment) (*SegmentHeader, error) {
+	buf := make([]byte, SegmentHeaderSize)
+	if _, err := seg.ReadAt(buf, 0); err != nil {
+		return nil, fmt.Errorf("%w: segment of %d bytes too small: %v", ErrSegmentMagic, seg.Size(), err)
+	}
+	if magic := binary.BigEndian.Uint32(buf[0:]); magic != segHeaderMagic {
+		return nil, fmt.Errorf("%w: magic %#08x, want %#08x", ErrSegmentMagic, magic, segHeaderMagic)
+	}
+	if buf[4] != segHeaderFormat {
+		return nil, fmt.Errorf("%w: %d, want %d", ErrSegmentFormat, buf[4], segHeaderFormat)
+	}
+	sum := binary.BigEndian.Uint32(buf[segHeaderCRCOffset:])
+	binary.BigEndian.PutUint32(buf[segHeaderCRCOffset:], 0)
+	if crc := crc32.ChecksumIEEE(buf); crc != sum {
+		return nil, fmt.Errorf("%w: %#08x, computed %#08x", ErrSegmentChecksum, sum, crc)
+	}
+	n := int(binary.BigEndian.Uint16(buf[16:]))
+	if n > segHeaderNameMax {
+		return nil, fmt.Errorf("%w: type name length %d", ErrSegmentFormat, n)
+	}
+	h := &SegmentHeader{
+		Version:  binary.BigEndian.Uint16(buf[6:]),
+		Capacity: binary.BigEndian.Uint64(buf[8:]),
+		TypeName: string(buf[24 : 24+n]),
+	}
+	if avail := uint64(seg.Size() - SegmentHeaderSize); h.Capacity > avail {
+		return nil, fmt.Errorf("%w: header claims %d bytes, segment holds %d", ErrSegmentCapacity, h.Capacity, avail)
+	}
+	return h, nil
+}
+
+// CheckSegmentHeader ... Reads the header of seg and verifies that it was written
+// for version and typeName
+func CheckSegmentHeader(seg *Segment, version uint16, typeName string) (*SegmentHeader, error) {
+	h, err := ReadSegmentHeader(seg)
+	if err != nil {
+		return nil, err
+	}
+	if h.TypeName != typeName {
+		return nil, fmt.Errorf("%w: segment holds %q, want %q", ErrSegmentType, h.TypeName, typeName)
+	}
+	if h.Version != version {
+		return nil, fmt.Errorf("%w: segment has layout version %d, want %d", ErrSegmentVersion, h.Version, version)
+	}
+	return h, nil
+}
+
+// CreateShared ... Writes a header naming T and version to seg and maps a T right after it.
+// The value is not cleared
+func CreateShared[T any](seg *Segment, version uint16) (*Shared[T], error) {
+	// validate T before anything is written
+	if err := checkSharedType(reflect.TypeFor[T](), reflect.TypeFor[T]().String()); err != nil {
+		return nil, err
+	}
+	if _, err := WriteSegmentHeader(seg, version, sharedTypeName[T]()); err != nil {
+		return nil, err
+	}
+	return NewShared[T](seg, SegmentHeaderSize)
+}
+
+// OpenShared ... Verifies that seg was set up by CreateShared for T and version and maps the value
+func OpenShared[T any](seg *Segment, version uint16) (*Shared[T], error) {
+	h, err := CheckSegmentHeader(seg, version, sharedTypeName[T]())
+	if err != nil {
+		return nil, err
+	}
+	if size := reflect.TypeFor[T]().Size(); h.Capacity < uint64(size) {
+		return nil, fmt.Errorf("%w: %d bytes, %s needs %d", ErrSegmentCapacity, h.Capacity, h.TypeName, size)
+	}
+	return NewShared[T](seg, SegmentHeaderSize)
+}
+
+// sharedTypeName names T by package path and type, so equally named types of different packages differ
+func sharedTypeName[T any]() string {
+	typ := reflect.TypeFor[T]()
+	if typ.PkgPath() == "" {
+		return typ.String()
+	}
+	return typ.PkgPath() + "." + typ.Name()
+}
+
+// AttachHeader ... Attaches segment id like Attach and verifies that its header was written
+// for version and typeName. On a mismatch the segment is detached again
+func (s *ShmInfo) AttachHeader(id, shmflg int, version uint16, typeName string) (*Segment, *SegmentHeader, error) {
+	seg, err := s.Attach(id, shmflg)
+	if err != nil {
+		return nil, nil, err
+	}
+	h, err := CheckSegmentHeader(seg, version, typeName)
+	if err != nil {
+		seg.Close()
+		return nil, nil, fmt.Errorf("segment %d: %w", id, err)
+	}
+	return seg, h, nil
+}
@@ -0,0 +1,90 @@
+package ipc
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type sharedCounter struct {
+	Hits   uint64
+	Misses uint64
+}
+
+func TestSegmentHeader_ReadWrite(t *testing.T) {
+	s, id := testSegment(t, 1024)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	_, err = ReadSegmentHeader(seg)
+	require.ErrorIs(t, err, ErrSegmentMagic)
+
+	h, err := WriteSegmentHeader(seg, 3, "orders")
+	require.NoError(t, err)
+	require.EqualValues(t, 1024-SegmentHeaderSize, h.Capacity)
+
+	got, err := CheckSegmentHeader(seg, 3, "orders")
+	require.NoError(t, err)
+	require.Equal(t, h, got)
+
+	_, err = CheckSegmentHeader(seg, 4, "orders")
+	require.ErrorIs(t, err, ErrSegmentVersion)
+	require.ErrorContains(t, err, "version 3, want 4")
+	_, err = CheckSegmentHeader(seg, 3, "quotes")
+	require.ErrorIs(t, err, ErrSegmentType)
+	require.ErrorContains(t, err, `"orders"`)
+
+	// a flipped byte in the header
+	seg.Bytes()[30] ^= 1
+	_, err = ReadSegmentHeader(seg)
+	require.ErrorIs(t, err, ErrSegmentChecksum)
+	seg.Bytes()[30] ^= 1
+
+	_, _, err = s.AttachHeader(id, SHM_RDONLY, 3, "quotes")
+	require.ErrorIs(t, err, ErrSegmentType)
+	other, h2, err := s.AttachHeader(id, SHM_RDONLY, 3, "orders")
+	require.NoError(t, err)
+	require.Equal(t, h, h2)
+	require.NoError(t, other.Close())
+
+	seg.Bytes()[4] = 9
+	_, err = ReadSegmentHeader(seg)
+	require.ErrorIs(t, err, ErrSegmentFormat)
+}
+
+func TestSegmentHeader_Shared(t *testing.T) {
+	s, id := testSegment(t, 256)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	w, err := CreateShared[sharedCounter](seg, 1)
+	require.NoError(t, err)
+	w.Get().Hits = 42
+	require.Equal(t, SegmentHeaderSize, w.Offset())
+
+	h, err := ReadSegmentHeader(seg)
+	require.NoError(t, err)
+	require.Equal(t, "github.com/denzelpenzel/ipc.sharedCounter", h.TypeName)
+
+	other, err := s.Attach(id, SHM_RDONLY)
+	require.NoError(t, err)
+	defer other.Close()
+	r, err := OpenShared[sharedCounter](other, 1)
+	require.NoError(t, err)
+	require.EqualValues(t, 42, r.Get().Hits)
+
+	_, err = OpenShared[sharedCounter](other, 2)
+	require.ErrorIs(t, err, ErrSegmentVersion)
+	_, err = OpenShared[sharedQuote](other, 1)
+	require.ErrorIs(t, err, ErrSegmentType)
+
+	// T is checked before the header is written
+	_, err = CreateShared[struct{ S string }](seg, 1)
+	require.Error(t, err)
+	_, err = OpenShared[sharedCounter](seg, 1)
+	require.NoError(t, err)
+
+	_, err = CreateShared[[256]byte](seg, 1)
+	require.ErrorContains(t, err, "does not fit")
+}
@@ -0,0 +1,228 @@
+package ipc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+var (
+	ErrSegmentClosed   = errors.New("[error] segment closed")
+	ErrSegmentReadOnly = errors.New("[error] segment attached read-only")
+	ErrSegmentBounds   = errors.New("[error] access beyond the end of the segment")
+
+	_ io.ReaderAt        = (*Segment)(nil)
+	_ io.WriterAt        = (*Segment)(nil)
+	_ io.ReadWriteSeeker = (*Segment)(nil)
+	_ io.Closer          = (*Segment)(nil)
+)
+
+// Segment ... Attached shared memory: System V (ShmInfo.Attach), POSIX (OpenPosixShm) or memfd (Memfd.Map).
+// It implements io.ReaderAt, io.WriterAt and io.ReadWriteSeeker over the segment memory, so callers
+// never handle the raw attach address. ReadAt and WriteAt may be called concurrently; Read, Write
+// and Seek share one offset. Access after Close fails with ErrSegmentClosed
+type Segment struct {
+	mu       sync.RWMutex
+	id       int    // System V identifier, -1 otherwise
+	key      uint64 // System V key
+	name     string // POSIX name
+	data     []byte // nil once detached
+	off      int64
+	readOnly bool
+	shm      *ShmInfo // System V only
+
+	detach func(data []byte) error
+	remove func() error
+}
+
+// Attach ... Attaches the segment id, like Shmat, and returns a handle to it
+func (s *ShmInfo) Attach(id int, shmflg int) (*Segment, error) {
+	addr, err := s.Shmat(id, shmflg)
+	if err != nil {
+		return nil, err
+	}
+	s.RLock()
+	size, key := s.addr2Size[addr], s.id2Key[id]
+	s.RUnlock()
+	if size == 0 {
+		s.Shmdt(addr)
+		return nil, fmt.Errorf("[error] unknown size of segment %d", id)
+	}
+	return &Segment{
+		id:       id,
+		key:      key,
+		data:     unsafe.Slice((*byte)(addr), size),
+		readOnly: shmflg&SHM_RDONLY != 0,
+		shm:      s,
+		detach: func(data []byte) error {
+			return s.Shmdt(unsafe.Pointer(unsafe.SliceData(data)))
+		},
+		remove: func() error {
+			return s.Shmctl(id, IPC_RMID)
+		},
+	}, nil
+}
+
+// ID ... Returns the System V shared memory identifier, -1 for other segments
+func (seg *Segment) ID() int {
+	return seg.id
+}
+
+// Key ... Returns the System V key the segment was created with, IPC_PRIVATE for private and other segments
+func (seg *Segment) Key() uint64 {
+	return seg.key
+}
+
+// Name ... Returns the POSIX name including the leading slash, empty for System V segments
+func (seg *Segment) Name() string {
+	return seg.name
+}
+
+// Stat ... Returns the kernel's view of a System V segment, see ShmidDs.
+// Other segments fail with errors.ErrUnsupported
+func (seg *Segment) Stat() (*ShmidDs, error) {
+	if seg.shm == nil {
+		return nil, errors.ErrUnsupported
+	}
+	return seg.shm.Stat(seg.id)
+}
+
+// Size ... Returns the segment size in bytes
+func (seg *Segment) Size() int64 {
+	seg.mu.RLock()
+	defer seg.mu.RUnlock()
+	return int64(len(seg.data))
+}
+
+// Bytes ... Returns the segment memory as a slice whose length and capacity are the
+// segment size, so indexing past the end panics instead of touching foreign memory.
+// The slice must not be used after Close; writing to it on a read-only segment faults
+func (seg *Segment) Bytes() []byte {
+	seg.mu.RLock()
+	defer seg.mu.RUnlock()
+	return seg.data
+}
+
+// ReadAt ... Implements io.ReaderAt
+func (seg *Segment) ReadAt(p []byte, off int64) (int, error) {
+	seg.mu.RLock()
+	defer seg.mu.RUnlock()
+	return seg.readAt(p, off)
+}
+
+// WriteAt ... Implements io.WriterAt. Writes crossing the end of the segment
+// store what fits and fail with ErrSegmentBounds
+func (seg *Segment) WriteAt(p []byte, off int64) (int, error) {
+	seg.mu.RLock()
+	defer seg.mu.RUnlock()
+	return seg.writeAt(p, off)
+}
+
+// Read ... Implements io.Reader, reading from the current offset
+func (seg *Segment) Read(p []byte) (int, error) {
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+	n, err := seg.readAt(p, seg.off)
+	seg.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Write ... Implements io.Writer, writing at the current offset
+func (seg *Segment) Write(p []byte) (int, error) {
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+	n, err := seg.writeAt(p, seg.off)
+	seg.off += int64(n)
+	return n, err
+}
+
+// Seek ... Implements io.Seeker. Seeking past the end is allowed,
+// reads there return io.EOF and writes fail
+func (seg *Segment) Seek(offset int64, whence int) (int64, error) {
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+	if seg.data == nil {
+		return 0, ErrSegmentClosed
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += seg.off
+	case io.SeekEnd:
+		offset += int64(len(seg.data))
+	default:
+		return 0, errors.New("[error] invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("[error] negative position")
+	}
+	seg.off = offset
+	return offset, nil
+}
+
+// Close ... Detaches the segment, the segment itself stays until Remove
+func (seg *Segment) Close() error {
+	seg.mu.Lock()
+	defer seg.mu.Unlock()
+	if seg.data == nil {
+		return ErrSegmentClosed
+	}
+	if err := seg.detach(seg.data); err != nil {
+		return err
+	}
+	seg.data = nil
+	return nil
+}
+
+// Remove ... Removes the segment and detaches it. System V segments are marked for removal
+// and destroyed after the last detach, POSIX names are unlinked and the memory is freed
+// once every process unmapped it. For memfd segments it is the same as Close
+func (seg *Segment) Remove() error {
+	err := seg.remove()
+	if cerr := seg.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (seg *Segment) readAt(p []byte, off int64) (int, error) {
+	if seg.data == nil {
+		return 0, ErrSegmentClosed
+	}
+	if off < 0 {
+		return 0, errors.New("[error] negative offset")
+	}
+	if off >= int64(len(seg.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, seg.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (seg *Segment) writeAt(p []byte, off int64) (int, error) {
+	if seg.data == nil {
+		return 0, ErrSegmentClosed
+	}
+	if seg.readOnly {
+		return 0, ErrSegmentReadOnly
+	}
+	if off < 0 {
+		return 0, errors.New("[error] negative offset")
+	}
+	if off >= int64(len(seg.data)) {
+		return 0, ErrSegmentBounds
+	}
+	n := copy(seg.data[off:], p)
+	if n < len(p) {
+		return n, ErrSegmentBounds
+	}
+	return n, nil
+}
@@ -0,0 +1,103 @@
+package ipc
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func testSegment(t *testing.T, size uint64) (*ShmInfo, int) {
+	s := NewShm()
+	id, err := s.Shmget(IPC_PRIVATE, size, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Shmctl(id, IPC_RMID) })
+	return s, id
+}
+
+func TestSegment_ReadWriteAt(t *testing.T) {
+	s, id := testSegment(t, 256)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+	require.Equal(t, id, seg.ID())
+	require.EqualValues(t, 256, seg.Size())
+
+	n, err := seg.WriteAt([]byte(want), 10)
+	require.NoError(t, err)
+	require.Equal(t, len(want), n)
+
+	// a second attachment sees the same memory
+	other, err := s.Attach(id, SHM_RDONLY)
+	require.NoError(t, err)
+	defer other.Close()
+	got := make([]byte, len(want))
+	_, err = other.ReadAt(got, 10)
+	require.NoError(t, err)
+	require.Equal(t, want, string(got))
+	require.Equal(t, want, string(other.Bytes()[10:10+len(want)]))
+
+	_, err = other.WriteAt([]byte("x"), 0)
+	require.ErrorIs(t, err, ErrSegmentReadOnly)
+
+	// bounds
+	n, err = seg.WriteAt([]byte("0123456789"), 252)
+	require.ErrorIs(t, err, ErrSegmentBounds)
+	require.Equal(t, 4, n)
+	n, err = seg.ReadAt(got, 252)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, 4, n)
+	_, err = seg.ReadAt(got, 256)
+	require.ErrorIs(t, err, io.EOF)
+	require.Len(t, seg.Bytes(), 256)
+	require.Equal(t, 256, cap(seg.Bytes()))
+}
+
+func TestSegment_Seek(t *testing.T) {
+	s, id := testSegment(t, 16)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	_, err = seg.Write([]byte("abcd"))
+	require.NoError(t, err)
+	_, err = seg.Write([]byte("efgh"))
+	require.NoError(t, err)
+
+	pos, err := seg.Seek(-4, io.SeekCurrent)
+	require.NoError(t, err)
+	require.EqualValues(t, 4, pos)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(seg, got)
+	require.NoError(t, err)
+	require.Equal(t, "efgh", string(got))
+
+	pos, err = seg.Seek(-2, io.SeekEnd)
+	require.NoError(t, err)
+	require.EqualValues(t, 14, pos)
+	rest, err := io.ReadAll(seg)
+	require.NoError(t, err)
+	require.Len(t, rest, 2)
+
+	_, err = seg.Seek(-1, io.SeekStart)
+	require.Error(t, err)
+}
+
+func TestSegment_Close(t *testing.T) {
+	s, id := testSegment(t, 16)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	require.NoError(t, seg.Close())
+
+	require.ErrorIs(t, seg.Close(), ErrSegmentClosed)
+	_, err = seg.ReadAt(make([]byte, 1), 0)
+	require.ErrorIs(t, err, ErrSegmentClosed)
+	_, err = seg.Write([]byte("x"))
+	require.ErrorIs(t, err, ErrSegmentClosed)
+	require.Nil(t, seg.Bytes())
+
+	// a fresh ShmInfo learns the size from the kernel
+	other, err := NewShm().Attach(id, 0)
+	require.NoError(t, err)
+	require.EqualValues(t, 16, other.Size())
+	require.NoError(t, other.Close())
+}
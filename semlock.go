@@ -134,6 +134,17 @@ func Semop(semid int, sops []SemOp) (bool, error) {
 	return ok, nil
 }
 
+// Semtimedop ... Like Semop, but a blocking operation gives up with EAGAIN once timeout has passed.
+// A nil timeout blocks indefinitely. Unlike Semop every failure, including EAGAIN, is returned
+func Semtimedop(semid int, sops []SemOp, timeout *syscall.Timespec) error {
+	_, _, err := syscall.Syscall6(syscall.SYS_SEMTIMEDOP, uintptr(semid), uintptr(unsafe.Pointer(&sops[0])),
+		uintptr(len(sops)), uintptr(unsafe.Pointer(timeout)), 0, 0)
+	if err != 0 {
+		return err
+	}
+	return nil
+}
+
 // Semctl ... Removes the semaphore set with the given id
 func Semctl(semid int, cmd int) error {
 	id, _, err := syscall.Syscall(syscall.SYS_SEMCTL, uintptr(semid), IPC_RMID, uintptr(cmd))
@@ -0,0 +1,210 @@
+package ipc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Sequence lock layout for shared memory, an alternative to the plain length prefix
+// of Shmwrite/Shmread that never returns a torn value:
+//
+//	| seq uint64 | writer uint64 | length uint32 | reserved uint32 | payload ... |
+//
+// A writer makes seq odd with a compare-and-swap, which also excludes other writers,
+// records itself in writer, stores the length and payload, then clears writer and makes
+// seq even again. Readers take no lock: they copy the payload and retry while seq was odd
+// or changed during the copy. The payload is copied in atomic 64-bit words, so the copies
+// stay ordered with the seq updates on weakly ordered CPUs too, and its capacity is rounded
+// down to whole words. A zeroed segment reads as an empty value.
+//
+// writer holds the pid of the writer and the low 32 bits of its pid namespace inode in the
+// upper half. A writer that dies mid-update leaves seq odd: readers fail with
+// ErrSeqlockWriterDied and the next writer takes the update over. Whether a writer died
+// can only be told within its pid namespace, and not once its pid is reused; otherwise
+// readers and writers give up with ErrSeqlockTimeout.
+
+const (
+	seqHeaderSize = 24
+	// attempts that only yield the processor before backing off with sleeps
+	seqSpins = 64
+)
+
+var (
+	ErrSeqlockTimeout    = errors.New("[error] seqlock writer did not finish in time")
+	ErrSeqlockWriterDied = errors.New("[error] seqlock writer died during an update")
+)
+
+// seqlockTimeout bounds how long readers retry and writers wait for another writer
+var seqlockTimeout = time.Second
+
+// ShmwriteSeq ... Writes data to the shared memory at addr using the sequence lock layout
+func (s *ShmInfo) ShmwriteSeq(addr unsafe.Pointer, data []byte) error {
+	s.RLock()
+	maxSize := s.addr2Size[addr]
+	s.RUnlock()
+	return seqWrite(unsafe.Slice((*byte)(addr), maxSize), data)
+}
+
+// ShmreadSeq ... Reads a consistent snapshot of the data written by ShmwriteSeq
+func (s *ShmInfo) ShmreadSeq(addr unsafe.Pointer) ([]byte, error) {
+	s.RLock()
+	maxSize := s.addr2Size[addr]
+	s.RUnlock()
+	return seqRead(unsafe.Slice((*byte)(addr), maxSize))
+}
+
+// WriteSeq ... Writes data to the segment using the sequence lock layout, see ShmwriteSeq
+func (seg *Segment) WriteSeq(data []byte) error {
+	seg.mu.RLock()
+	defer seg.mu.RUnlock()
+	if seg.data == nil {
+		return ErrSegmentClosed
+	}
+	if seg.readOnly {
+		return ErrSegmentReadOnly
+	}
+	return seqWrite(seg.data, data)
+}
+
+// ReadSeq ... Reads a consistent snapshot of the data written by WriteSeq
+func (seg *Segment) ReadSeq() ([]byte, error) {
+	seg.mu.RLock()
+	defer seg.mu.RUnlock()
+	if seg.data == nil {
+		return nil, ErrSegmentClosed
+	}
+	return seqRead(seg.data)
+}
+
+func seqWrite(mem []byte, data []byte) error {
+	if len(mem) < seqHeaderSize || len(data) > seqCapacity(mem) {
+		return fmt.Errorf("not enough space, (%d + %d) > %d", seqHeaderSize, len(data), len(mem))
+	}
+	seq, writer := seqCounter(mem), seqWriter(mem)
+	self := seqWriterID()
+	deadline := time.Now().Add(seqlockTimeout)
+	for spins := 0; ; spins++ {
+		v := seq.Load()
+		if v&1 == 0 && seq.CompareAndSwap(v, v+1) {
+			writer.Store(self)
+			break
+		}
+		// seq stays odd while the update of a dead writer is taken over
+		if w := writer.Load(); v&1 != 0 && spins >= seqSpins && seqWriterDead(w) && writer.CompareAndSwap(w, self) {
+			break
+		}
+		if err := seqBackoff(spins, deadline); err != nil {
+			return err
+		}
+	}
+	seqLength(mem).Store(uint32(len(data)))
+	seqStore(mem, data)
+	writer.Store(0)
+	seq.Add(1)
+	return nil
+}
+
+func seqRead(mem []byte) ([]byte, error) {
+	if len(mem) < seqHeaderSize {
+		return nil, fmt.Errorf("not enough space, %d < %d", len(mem), seqHeaderSize)
+	}
+	seq, length := seqCounter(mem), seqLength(mem)
+	var buf []byte
+	deadline := time.Now().Add(seqlockTimeout)
+	for spins := 0; ; spins++ {
+		before := seq.Load()
+		if before&1 == 0 {
+			// a torn length is only bounded here, the retry below discards it
+			n := min(int(length.Load()), seqCapacity(mem))
+			if cap(buf) < n {
+				buf = make([]byte, n)
+			}
+			buf = buf[:n]
+			seqLoad(mem, buf)
+			if seq.Load() == before {
+				return buf, nil
+			}
+		} else if spins >= seqSpins && seqWriterDead(seqWriter(mem).Load()) {
+			return nil, ErrSeqlockWriterDied
+		}
+		if err := seqBackoff(spins, deadline); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// seqStore copies data into the payload one atomic word at a time, the last word is zero padded
+func seqStore(mem []byte, data []byte) {
+	words := seqWords(mem, len(data))
+	var w [8]byte
+	for i := range words {
+		clear(w[:])
+		copy(w[:], data[i*8:])
+		words[i].Store(binary.NativeEndian.Uint64(w[:]))
+	}
+}
+
+// seqLoad fills buf from the payload one atomic word at a time
+func seqLoad(mem []byte, buf []byte) {
+	words := seqWords(mem, len(buf))
+	var w [8]byte
+	for i := range words {
+		binary.NativeEndian.PutUint64(w[:], words[i].Load())
+		copy(buf[i*8:], w[:])
+	}
+}
+
+// seqWords returns the payload words covering n bytes
+func seqWords(mem []byte, n int) []atomic.Uint64 {
+	if n == 0 {
+		return nil
+	}
+	return unsafe.Slice((*atomic.Uint64)(unsafe.Pointer(&mem[seqHeaderSize])), (n+7)/8)
+}
+
+// seqCapacity returns the payload size in whole words
+func seqCapacity(mem []byte) int {
+	return (len(mem) - seqHeaderSize) &^ 7
+}
+
+// seqWriterID returns the writer word of this process
+func seqWriterID() uint64 {
+	return uint64(uint32(pidNamespaceID()))<<32 | uint64(uint32(os.Getpid()))
+}
+
+// seqWriterDead reports whether writer names a process of this pid namespace that exited
+func seqWriterDead(writer uint64) bool {
+	ns := uint32(pidNamespaceID())
+	return writer != 0 && ns != 0 && uint32(writer>>32) == ns && !processAlive(int(uint32(writer)))
+}
+
+// seqBackoff yields the processor between attempts and fails once deadline has passed
+func seqBackoff(spins int, deadline time.Time) error {
+	if spins < seqSpins {
+		runtime.Gosched()
+		return nil
+	}
+	if time.Now().After(deadline) {
+		return ErrSeqlockTimeout
+	}
+	time.Sleep(msgPollMin)
+	return nil
+}
+
+func seqCounter(mem []byte) *atomic.Uint64 {
+	return (*atomic.Uint64)(unsafe.Pointer(&mem[0]))
+}
+
+func seqWriter(mem []byte) *atomic.Uint64 {
+	return (*atomic.Uint64)(unsafe.Pointer(&mem[8]))
+}
+
+func seqLength(mem []byte) *atomic.Uint32 {
+	return (*atomic.Uint32)(unsafe.Pointer(&mem[16]))
+}
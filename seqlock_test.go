@@ -0,0 +1,137 @@
+package ipc
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/require"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSegment_Seqlock(t *testing.T) {
+	s, id := testSegment(t, 256)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	got, err := seg.ReadSeq()
+	require.NoError(t, err)
+	require.Empty(t, got)
+
+	require.NoError(t, seg.WriteSeq([]byte(want)))
+	got, err = seg.ReadSeq()
+	require.NoError(t, err)
+	require.Equal(t, want, string(got))
+
+	require.Error(t, seg.WriteSeq(make([]byte, 256-seqHeaderSize+1)))
+
+	// the ShmInfo functions use the same layout
+	addr, err := s.Shmat(id, 0)
+	require.NoError(t, err)
+	defer s.Shmdt(addr)
+	got, err = s.ShmreadSeq(addr)
+	require.NoError(t, err)
+	require.Equal(t, want, string(got))
+	require.NoError(t, s.ShmwriteSeq(addr, []byte("test")))
+	got, err = seg.ReadSeq()
+	require.NoError(t, err)
+	require.Equal(t, "test", string(got))
+}
+
+func TestSegment_SeqlockConsistent(t *testing.T) {
+	s, id := testSegment(t, 4096)
+	writer, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer writer.Close()
+	reader, err := s.Attach(id, SHM_RDONLY)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// every value is filled with one byte and its length is derived from that byte
+	var wg sync.WaitGroup
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; ctx.Err() == nil; i += 2 {
+				b := byte(i)
+				if err := writer.WriteSeq(bytes.Repeat([]byte{b}, 1+int(b)*15)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	reads := 0
+	for ctx.Err() == nil {
+		got, err := reader.ReadSeq()
+		require.NoError(t, err)
+		if len(got) == 0 {
+			continue
+		}
+		require.Len(t, got, 1+int(got[0])*15)
+		require.Equal(t, bytes.Repeat(got[:1], len(got)), got)
+		reads++
+	}
+	wg.Wait()
+	require.Greater(t, reads, 0)
+}
+
+func TestSegment_SeqlockStuckWriter(t *testing.T) {
+	defer func(d time.Duration) { seqlockTimeout = d }(seqlockTimeout)
+	seqlockTimeout = 50 * time.Millisecond
+
+	s, id := testSegment(t, 64)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	// a writer died between the two increments before it recorded itself
+	seqCounter(seg.Bytes()).Store(1)
+	_, err = seg.ReadSeq()
+	require.ErrorIs(t, err, ErrSeqlockTimeout)
+	require.ErrorIs(t, seg.WriteSeq([]byte("x")), ErrSeqlockTimeout)
+}
+
+func TestSegment_SeqlockDeadWriter(t *testing.T) {
+	if pidNamespaceID() == 0 {
+		t.Skip("pid namespace unknown")
+	}
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	dead := uint64(uint32(pidNamespaceID()))<<32 | uint64(cmd.Process.Pid)
+
+	s, id := testSegment(t, 64)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	// the writer died in the middle of an update
+	mem := seg.Bytes()
+	seqCounter(mem).Store(1)
+	seqWriter(mem).Store(dead)
+	_, err = seg.ReadSeq()
+	require.ErrorIs(t, err, ErrSeqlockWriterDied)
+
+	// the next writer finishes the update
+	require.NoError(t, seg.WriteSeq([]byte("taken over")))
+	require.EqualValues(t, 2, seqCounter(mem).Load())
+	require.Zero(t, seqWriter(mem).Load())
+	got, err := seg.ReadSeq()
+	require.NoError(t, err)
+	require.Equal(t, "taken over", string(got))
+
+	// a writer of another pid namespace cannot be judged
+	seqCounter(mem).Store(3)
+	seqWriter(mem).Store(dead + 1<<32)
+	defer func(d time.Duration) { seqlockTimeout = d }(seqlockTimeout)
+	seqlockTimeout = 50 * time.Millisecond
+	_, err = seg.ReadSeq()
+	require.ErrorIs(t, err, ErrSeqlockTimeout)
+}
@@ -0,0 +1,79 @@
+package ipc
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// Shared ... Typed view of a value of type T stored in a shared memory segment.
+// T must be free of pointers, slices, maps, strings, interfaces, channels and functions:
+// those refer to memory of one process and would be garbage in every other one.
+// Processes agree on the layout by using the same type compiled for the same architecture
+type Shared[T any] struct {
+	seg    *Segment
+	offset int
+	ptr    *T
+}
+
+// NewShared ... Maps a T at offset bytes into seg. It fails if T is not pointer free,
+// the segment is too small or the address is not aligned for T.
+// The value is only valid until the segment is closed; on a read-only segment writing it faults
+func NewShared[T any](seg *Segment, offset int) (*Shared[T], error) {
+	typ := reflect.TypeFor[T]()
+	if err := checkSharedType(typ, typ.String()); err != nil {
+		return nil, err
+	}
+	mem := seg.Bytes()
+	if mem == nil {
+		return nil, ErrSegmentClosed
+	}
+	size := int(typ.Size())
+	if offset < 0 || offset > len(mem) || len(mem)-offset < size {
+		return nil, fmt.Errorf("[error] %s of %d bytes at offset %d does not fit in segment of %d bytes",
+			typ, size, offset, len(mem))
+	}
+	addr := unsafe.Add(unsafe.Pointer(unsafe.SliceData(mem)), offset)
+	if align := uintptr(typ.Align()); uintptr(addr)%align != 0 {
+		return nil, fmt.Errorf("[error] offset %d is not aligned to %d bytes for %s", offset, align, typ)
+	}
+	return &Shared[T]{seg: seg, offset: offset, ptr: (*T)(addr)}, nil
+}
+
+// Get ... Returns the shared value. Concurrent access from several processes needs
+// the same care as between goroutines, e.g. sync/atomic types or a Lock
+func (s *Shared[T]) Get() *T {
+	return s.ptr
+}
+
+// Segment ... Returns the segment holding the value
+func (s *Shared[T]) Segment() *Segment {
+	return s.seg
+}
+
+// Offset ... Returns the position of the value in the segment
+func (s *Shared[T]) Offset() int {
+	return s.offset
+}
+
+// checkSharedType reports the first part of typ, reached through path, that cannot be shared
+func checkSharedType(typ reflect.Type, path string) error {
+	switch typ.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return nil
+	case reflect.Array:
+		return checkSharedType(typ.Elem(), path+"[]")
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if err := checkSharedType(f.Type, path+"."+f.Name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("[error] %s is a %s and cannot be placed in shared memory", path, typ.Kind())
+}
@@ -0,0 +1,79 @@
+package ipc
+
+import (
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+)
+
+type sharedQuote struct {
+	Seq    atomic.Uint64
+	Symbol [8]byte
+	Bid    float64
+	Ask    float64
+	Sizes  [4]struct{ Bid, Ask int32 }
+	Halted bool
+}
+
+func TestShared_Map(t *testing.T) {
+	s, id := testSegment(t, 4096)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	w, err := NewShared[sharedQuote](seg, 64)
+	require.NoError(t, err)
+	q := w.Get()
+	copy(q.Symbol[:], "EURUSD")
+	q.Bid, q.Ask = 1.0841, 1.0843
+	q.Sizes[2].Ask = 7
+	q.Seq.Add(1)
+
+	// another attachment sees the same value at another address
+	other, err := s.Attach(id, SHM_RDONLY)
+	require.NoError(t, err)
+	defer other.Close()
+	r, err := NewShared[sharedQuote](other, 64)
+	require.NoError(t, err)
+	require.NotSame(t, q, r.Get())
+	require.EqualValues(t, 1, r.Get().Seq.Load())
+	require.Equal(t, "EURUSD", string(r.Get().Symbol[:6]))
+	require.Equal(t, 1.0843, r.Get().Ask)
+	require.EqualValues(t, 7, r.Get().Sizes[2].Ask)
+	require.Equal(t, 64, r.Offset())
+}
+
+func TestShared_Checks(t *testing.T) {
+	s, id := testSegment(t, 64)
+	seg, err := s.Attach(id, 0)
+	require.NoError(t, err)
+	defer seg.Close()
+
+	_, err = NewShared[struct{ Name string }](seg, 0)
+	require.ErrorContains(t, err, ".Name is a string")
+	_, err = NewShared[struct{ Data [2][]byte }](seg, 0)
+	require.ErrorContains(t, err, ".Data[] is a slice")
+	_, err = NewShared[struct {
+		Inner struct{ M map[int]int }
+	}](seg, 0)
+	require.ErrorContains(t, err, ".Inner.M is a map")
+	_, err = NewShared[*int](seg, 0)
+	require.ErrorContains(t, err, "is a ptr")
+	_, err = NewShared[struct{ V any }](seg, 0)
+	require.ErrorContains(t, err, "is a interface")
+	_, err = NewShared[atomic.Pointer[int]](seg, 0)
+	require.Error(t, err)
+
+	_, err = NewShared[[8]uint64](seg, 8)
+	require.ErrorContains(t, err, "does not fit")
+	_, err = NewShared[uint64](seg, 4)
+	require.ErrorContains(t, err, "not aligned")
+	_, err = NewShared[uint64](seg, -8)
+	require.Error(t, err)
+	_, err = NewShared[[8]uint64](seg, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, seg.Close())
+	_, err = NewShared[uint64](seg, 0)
+	require.ErrorIs(t, err, ErrSegmentClosed)
+}
@@ -0,0 +1,420 @@
+package ipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Bounded multi-producer/multi-consumer queue in a System V shared memory segment,
+// following Dmitry Vyukov's array queue: every slot carries a sequence number telling
+// producers and consumers whose turn it is. Layout, header fields on separate cache lines:
+//
+//	| magic uint32 | reserved uint32 | slots uint64 | slot size uint64 | semid+1 int64 | recovered uint64 |
+//	| pid namespace uint64 | ... |
+//	| enqueue position uint64 | ... | dequeue position uint64 | ... | slot 0 | slot 1 | ...
+//
+//	slot: | seq uint64 | owner uint64 | length uint32 | reserved uint32 | payload, padded to 8 bytes |
+//
+// seq is stored minus the slot index, so a zeroed segment is an empty queue.
+// A producer claims a slot by writing its pid and the slot position into owner before
+// it advances the enqueue position, other producers help advancing it. If the producer
+// dies before publishing the record, a consumer finding the slot claimed by a process
+// that no longer exists publishes a tombstone instead, which is skipped on dequeue.
+// The recovering consumer marks owner with its own pid first, should it die as well the
+// next consumer takes the recovery over. Before writing the record the producer moves owner
+// to a publishing state, so a producer whose slot was taken over backs off instead of
+// overwriting a slot that may already be reused.
+//
+// Pids only mean something within the pid namespace recorded by the creator of the queue.
+// Claims of producers from other namespaces are flagged and never recovered, and consumers
+// from other namespaces do not recover at all: a dead producer outside the creator's namespace
+// stalls the queue. Within the namespace a reused pid looks alive and delays recovery until
+// that process exits.
+// A consumer dying between claiming and releasing a slot is not recovered.
+
+const (
+	sharedQueueMagic      = 0x4f43504d // "MPCO"
+	sharedQueueHeaderSize = 3 * cacheLineSize
+	sharedSlotHeaderSize  = 24
+	sharedTombstone       = 1<<32 - 1
+	sharedRecovering      = 1 << 63 // owner flag of a slot being tombstoned by a consumer
+	sharedPublishing      = 1 << 62 // owner flag of a slot whose record is being written
+	sharedForeign         = 1 << 61 // owner flag of a claim from outside the queue's pid namespace
+	sharedOwnerPid        = 1<<29 - 1
+
+	// semaphores of the set used for blocking
+	semItems = 0 // posted after every enqueue
+	semSlots = 1 // posted after every dequeue
+
+	// sharedQueueWaitMax bounds a semaphore wait, so lost or leaked wakeups only add latency
+	sharedQueueWaitMax = 100 * time.Millisecond
+)
+
+var (
+	ErrSharedQueueFull  = errors.New("[error] shared queue full")
+	ErrSharedQueueEmpty = errors.New("[error] shared queue empty")
+)
+
+// SharedQueueConfig ... Shape of a new SharedQueue
+type SharedQueueConfig struct {
+	Slots    int // number of records the queue holds, a power of two
+	SlotSize int // largest record in bytes, records may be shorter
+	// Semaphores wakes blocked Send and Receive calls through a System V semaphore set
+	// as soon as the queue changes, instead of polling
+	Semaphores bool
+}
+
+// SharedQueue ... Handle to a shared memory MPMC queue. Any number of processes and
+// goroutines may send and receive concurrently through one or more handles
+type SharedQueue struct {
+	seg       *Segment
+	slots     uint64
+	slotSize  int
+	stride    uint64
+	semid     int // -1 without semaphores
+	recovered *atomic.Uint64
+	foreign   bool // this process lives outside the pid namespace of the queue
+	enq       *atomic.Uint64
+	deq       *atomic.Uint64
+	mem       []byte
+}
+
+type sharedSlot struct {
+	seq    atomic.Uint64
+	owner  atomic.Uint64
+	length atomic.Uint32
+	_      uint32
+}
+
+// CreateSharedQueue ... Creates the queue associated with the key if it does not exist yet
+// and attaches it. perm holds the access permissions, e.g. IPC_RW. An existing queue must
+// have the shape given by cfg
+func CreateSharedQueue(key uint64, perm int, cfg SharedQueueConfig) (*SharedQueue, error) {
+	if cfg.Slots < 2 || cfg.Slots&(cfg.Slots-1) != 0 {
+		return nil, fmt.Errorf("[error] shared queue slots %d is not a power of two >= 2", cfg.Slots)
+	}
+	if cfg.SlotSize <= 0 || uint64(cfg.SlotSize) >= sharedTombstone {
+		return nil, fmt.Errorf("[error] invalid shared queue slot size %d", cfg.SlotSize)
+	}
+	stride := sharedSlotStride(cfg.SlotSize)
+	s := NewShm()
+	id, err := s.Shmget(key, uint64(sharedQueueHeaderSize)+uint64(cfg.Slots)*stride, IPC_CREAT|perm)
+	if err != nil {
+		return nil, err
+	}
+	seg, err := s.Attach(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	mem := seg.Bytes()
+	fail := func(err error) (*SharedQueue, error) {
+		seg.Close()
+		return nil, err
+	}
+
+	if (*atomic.Uint32)(unsafe.Pointer(&mem[0])).Load() != sharedQueueMagic {
+		// racing creators agree on the shape, the first semaphore set wins
+		(*atomic.Uint64)(unsafe.Pointer(&mem[8])).CompareAndSwap(0, uint64(cfg.Slots))
+		(*atomic.Uint64)(unsafe.Pointer(&mem[16])).CompareAndSwap(0, uint64(cfg.SlotSize))
+		(*atomic.Uint64)(unsafe.Pointer(&mem[40])).CompareAndSwap(0, pidNamespaceID())
+		if cfg.Semaphores {
+			semid, err := Semget(IPC_PRIVATE, 2, IPC_CREAT|perm)
+			if err != nil {
+				return fail(err)
+			}
+			if !(*atomic.Int64)(unsafe.Pointer(&mem[24])).CompareAndSwap(0, int64(semid)+1) {
+				Semctl(semid, IPC_RMID)
+			}
+		}
+		(*atomic.Uint32)(unsafe.Pointer(&mem[0])).CompareAndSwap(0, sharedQueueMagic)
+	}
+	q, err := newSharedQueue(seg)
+	if err != nil {
+		return fail(err)
+	}
+	if q.slots != uint64(cfg.Slots) || q.slotSize != cfg.SlotSize {
+		return fail(fmt.Errorf("[error] shared queue has %d slots of %d bytes, want %d of %d",
+			q.slots, q.slotSize, cfg.Slots, cfg.SlotSize))
+	}
+	return q, nil
+}
+
+// OpenSharedQueue ... Attaches the existing queue associated with the key
+func OpenSharedQueue(key uint64) (*SharedQueue, error) {
+	s := NewShm()
+	id, err := s.Shmget(key, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	seg, err := s.Attach(id, 0)
+	if err != nil {
+		return nil, err
+	}
+	q, err := newSharedQueue(seg)
+	if err != nil {
+		seg.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func newSharedQueue(seg *Segment) (*SharedQueue, error) {
+	mem := seg.Bytes()
+	if len(mem) < sharedQueueHeaderSize || (*atomic.Uint32)(unsafe.Pointer(&mem[0])).Load() != sharedQueueMagic {
+		return nil, fmt.Errorf("[error] segment %d is not a shared queue", seg.ID())
+	}
+	slots := (*atomic.Uint64)(unsafe.Pointer(&mem[8])).Load()
+	slotSize := (*atomic.Uint64)(unsafe.Pointer(&mem[16])).Load()
+	if slots == 0 || slots&(slots-1) != 0 || slotSize == 0 || slotSize >= sharedTombstone ||
+		slots*sharedSlotStride(int(slotSize)) > uint64(len(mem)-sharedQueueHeaderSize) {
+		return nil, fmt.Errorf("[error] segment %d: invalid shared queue shape %d x %d", seg.ID(), slots, slotSize)
+	}
+	return &SharedQueue{
+		seg:       seg,
+		slots:     slots,
+		slotSize:  int(slotSize),
+		stride:    sharedSlotStride(int(slotSize)),
+		semid:     int((*atomic.Int64)(unsafe.Pointer(&mem[24])).Load()) - 1,
+		recovered: (*atomic.Uint64)(unsafe.Pointer(&mem[32])),
+		foreign:   (*atomic.Uint64)(unsafe.Pointer(&mem[40])).Load() != pidNamespaceID(),
+		enq:       (*atomic.Uint64)(unsafe.Pointer(&mem[cacheLineSize])),
+		deq:       (*atomic.Uint64)(unsafe.Pointer(&mem[2*cacheLineSize])),
+		mem:       mem,
+	}, nil
+}
+
+func sharedSlotStride(slotSize int) uint64 {
+	return uint64(sharedSlotHeaderSize + (slotSize+7)&^7)
+}
+
+// ownerTag identifies the lap of position pos in a slot owner word, it is never 0 for a fresh slot
+func ownerTag(pos uint64) uint64 {
+	return uint64(uint32(pos + 1))
+}
+
+// ID ... Returns the identifier of the underlying segment
+func (q *SharedQueue) ID() int {
+	return q.seg.ID()
+}
+
+// Cap ... Returns the number of slots
+func (q *SharedQueue) Cap() int {
+	return int(q.slots)
+}
+
+// SlotSize ... Returns the largest record the queue accepts
+func (q *SharedQueue) SlotSize() int {
+	return q.slotSize
+}
+
+// Len ... Returns the number of claimed slots, records being written or read included
+func (q *SharedQueue) Len() int {
+	deq := q.deq.Load()
+	return int(min(q.enq.Load()-deq, q.slots))
+}
+
+// Recovered ... Returns how many records were dropped because their producer died mid-send
+func (q *SharedQueue) Recovered() uint64 {
+	return q.recovered.Load()
+}
+
+// TrySend ... Enqueues one record, failing with ErrSharedQueueFull if no slot is free
+func (q *SharedQueue) TrySend(data []byte) error {
+	if len(data) > q.slotSize {
+		return fmt.Errorf("[error] record of %d bytes exceeds slot size %d", len(data), q.slotSize)
+	}
+	claim := uint64(os.Getpid()) << 32
+	if q.foreign {
+		claim |= sharedForeign
+	}
+	for {
+		pos := q.enq.Load()
+		s, payload := q.slot(pos)
+		seq := q.seq(s, pos)
+		switch {
+		case seq == pos:
+			owner := s.owner.Load()
+			if owner&0xffffffff == ownerTag(pos) {
+				// claimed by a producer that did not advance the position yet
+				q.enq.CompareAndSwap(pos, pos+1)
+				continue
+			}
+			if !s.owner.CompareAndSwap(owner, claim|ownerTag(pos)) {
+				continue
+			}
+			q.enq.CompareAndSwap(pos, pos+1)
+			if !q.publish(s, pos, claim|ownerTag(pos), payload, data) {
+				// a consumer took the slot over, the record goes into the next one
+				continue
+			}
+			q.post(semItems)
+			return nil
+		case int64(seq-pos) < 0:
+			return ErrSharedQueueFull
+		}
+	}
+}
+
+// TryReceive ... Dequeues the oldest record, failing with ErrSharedQueueEmpty if there is none
+func (q *SharedQueue) TryReceive() ([]byte, error) {
+	for {
+		pos := q.deq.Load()
+		s, payload := q.slot(pos)
+		seq := q.seq(s, pos)
+		switch {
+		case seq == pos+1:
+			if !q.deq.CompareAndSwap(pos, pos+1) {
+				continue
+			}
+			var data []byte
+			n := s.length.Load()
+			if n != sharedTombstone {
+				data = make([]byte, n)
+				copy(data, payload)
+			}
+			q.setSeq(s, pos, pos+q.slots)
+			q.post(semSlots)
+			if n != sharedTombstone {
+				return data, nil
+			}
+		case int64(seq-(pos+1)) < 0:
+			if !q.recover(pos, s) {
+				return nil, ErrSharedQueueEmpty
+			}
+		}
+	}
+}
+
+// publish writes data to the slot claimed with owner at position pos. It fails without
+// touching the slot if a consumer recovered the claim meanwhile
+func (q *SharedQueue) publish(s *sharedSlot, pos, owner uint64, payload, data []byte) bool {
+	if !s.owner.CompareAndSwap(owner, owner|sharedPublishing) {
+		return false
+	}
+	copy(payload, data)
+	s.length.Store(uint32(len(data)))
+	q.setSeq(s, pos, pos+1)
+	return true
+}
+
+// recover publishes a tombstone for position pos if the producer that claimed it is gone.
+// It reports whether the slot changed and is worth another look
+func (q *SharedQueue) recover(pos uint64, s *sharedSlot) bool {
+	owner := s.owner.Load()
+	if owner&0xffffffff != ownerTag(pos) {
+		return false
+	}
+	if q.foreign || owner&sharedForeign != 0 {
+		// the pid cannot be checked from here
+		return false
+	}
+	pid := int(owner >> 32 & sharedOwnerPid)
+	if pid != 0 && processAlive(pid) {
+		// a producer still writing, or another consumer recovering the slot
+		return owner&sharedRecovering != 0
+	}
+	claim := sharedRecovering | uint64(os.Getpid())<<32 | ownerTag(pos)
+	if !s.owner.CompareAndSwap(owner, claim) {
+		return true
+	}
+	q.enq.CompareAndSwap(pos, pos+1)
+	s.length.Store(sharedTombstone)
+	q.setSeq(s, pos, pos+1)
+	q.recovered.Add(1)
+	return true
+}
+
+// Send ... Enqueues one record, waiting while the queue is full until a slot is free or ctx is done
+func (q *SharedQueue) Send(ctx context.Context, data []byte) error {
+	spins, delay := 0, msgPollMin
+	for {
+		err := q.TrySend(data)
+		if !errors.Is(err, ErrSharedQueueFull) {
+			return err
+		}
+		if err := q.wait(ctx, semSlots, &spins, &delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Receive ... Dequeues the oldest record, waiting until one arrives or ctx is done
+func (q *SharedQueue) Receive(ctx context.Context) ([]byte, error) {
+	spins, delay := 0, msgPollMin
+	for {
+		data, err := q.TryReceive()
+		if !errors.Is(err, ErrSharedQueueEmpty) {
+			return data, err
+		}
+		if err := q.wait(ctx, semItems, &spins, &delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Close ... Detaches the queue, it stays in place until Remove
+func (q *SharedQueue) Close() error {
+	return q.seg.Close()
+}
+
+// Remove ... Removes the semaphore set, marks the segment for removal and detaches it
+func (q *SharedQueue) Remove() error {
+	var err error
+	if q.semid >= 0 {
+		err = Semctl(q.semid, IPC_RMID)
+	}
+	if rerr := q.seg.Remove(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (q *SharedQueue) slot(pos uint64) (*sharedSlot, []byte) {
+	off := sharedQueueHeaderSize + (pos&(q.slots-1))*q.stride
+	s := (*sharedSlot)(unsafe.Pointer(&q.mem[off]))
+	return s, q.mem[off+sharedSlotHeaderSize : off+sharedSlotHeaderSize+uint64(q.slotSize)]
+}
+
+// seq returns the sequence number of slot s, which holds position pos
+func (q *SharedQueue) seq(s *sharedSlot, pos uint64) uint64 {
+	return s.seq.Load() + pos&(q.slots-1)
+}
+
+func (q *SharedQueue) setSeq(s *sharedSlot, pos, seq uint64) {
+	s.seq.Store(seq - pos&(q.slots-1))
+}
+
+// post wakes one waiter of semaphore num. Surplus wakeups are harmless, a full count is ignored
+func (q *SharedQueue) post(num uint16) {
+	if q.semid >= 0 {
+		Semop(q.semid, []SemOp{{SemNum: num, SemOp: 1, SemFlag: IPC_NOWAIT}})
+	}
+}
+
+// wait blocks on semaphore num, or polls without semaphores, until woken or ctx is done
+func (q *SharedQueue) wait(ctx context.Context, num uint16, spins *int, delay *time.Duration) error {
+	if q.semid < 0 {
+		return ringWait(ctx, spins, delay)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	timeout := sharedQueueWaitMax
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = max(min(timeout, time.Until(deadline)), time.Microsecond)
+	}
+	ts := syscall.NsecToTimespec(int64(timeout))
+	err := Semtimedop(q.semid, []SemOp{{SemNum: num, SemOp: -1}}, &ts)
+	switch {
+	case err == nil, errors.Is(err, syscall.EAGAIN), errors.Is(err, syscall.EINTR):
+		return ctx.Err()
+	default:
+		return err
+	}
+}
@@ -0,0 +1,263 @@
+package ipc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestSharedQueue_SendAndReceive(t *testing.T) {
+	key, err := Ftok("test/a", 20)
+	require.NoError(t, err)
+	cfg := SharedQueueConfig{Slots: 4, SlotSize: 130}
+	producer, err := CreateSharedQueue(key, IPC_RW, cfg)
+	require.NoError(t, err)
+	defer producer.Remove()
+
+	consumer, err := OpenSharedQueue(key)
+	require.NoError(t, err)
+	defer consumer.Close()
+	require.Equal(t, 4, consumer.Cap())
+	require.Equal(t, 130, consumer.SlotSize())
+
+	_, err = consumer.TryReceive()
+	require.ErrorIs(t, err, ErrSharedQueueEmpty)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, producer.TrySend([]byte(want)))
+		require.NoError(t, producer.TrySend(nil))
+		got, err := consumer.TryReceive()
+		require.NoError(t, err)
+		require.Equal(t, want, string(got))
+		got, err = consumer.TryReceive()
+		require.NoError(t, err)
+		require.Empty(t, got)
+	}
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, producer.TrySend([]byte{byte(i)}))
+	}
+	require.Equal(t, 4, producer.Len())
+	require.ErrorIs(t, producer.TrySend(nil), ErrSharedQueueFull)
+	require.Error(t, producer.TrySend(make([]byte, 131)))
+
+	// an existing queue keeps its shape
+	_, err = CreateSharedQueue(key, IPC_RW, SharedQueueConfig{Slots: 8, SlotSize: 130})
+	require.Error(t, err)
+	_, err = CreateSharedQueue(key, IPC_RW, SharedQueueConfig{Slots: 3, SlotSize: 130})
+	require.Error(t, err)
+}
+
+func testSharedQueueMPMC(t *testing.T, cfg SharedQueueConfig) {
+	q, err := CreateSharedQueue(IPC_PRIVATE, IPC_RW, cfg)
+	require.NoError(t, err)
+	defer q.Remove()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const producers, consumers, n = 4, 4, 2000
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				msg := make([]byte, 8+i%8)
+				binary.LittleEndian.PutUint64(msg, uint64(p*n+i))
+				if err := q.Send(ctx, msg); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(p)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[uint64]bool, producers*n)
+	for c := 0; c < consumers; c++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < producers*n/consumers; i++ {
+				got, err := q.Receive(ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				v := binary.LittleEndian.Uint64(got)
+				mu.Lock()
+				seen[v] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	require.Len(t, seen, producers*n)
+	require.Zero(t, q.Len())
+
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	_, err = q.Receive(short)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSharedQueue_MPMC(t *testing.T) {
+	testSharedQueueMPMC(t, SharedQueueConfig{Slots: 16, SlotSize: 16})
+}
+
+func TestSharedQueue_MPMCSemaphores(t *testing.T) {
+	testSharedQueueMPMC(t, SharedQueueConfig{Slots: 16, SlotSize: 16, Semaphores: true})
+}
+
+func TestSharedQueue_ProducerCrash(t *testing.T) {
+	q, err := CreateSharedQueue(IPC_PRIVATE, IPC_RW, SharedQueueConfig{Slots: 4, SlotSize: 8})
+	require.NoError(t, err)
+	defer q.Remove()
+
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	dead := uint64(cmd.Process.Pid)
+
+	// a producer claimed the next slot and died before publishing it
+	pos := q.enq.Load()
+	s, _ := q.slot(pos)
+	s.owner.Store(dead<<32 | ownerTag(pos))
+
+	require.NoError(t, q.TrySend([]byte("after")))
+	got, err := q.TryReceive()
+	require.NoError(t, err)
+	require.Equal(t, "after", string(got))
+	require.EqualValues(t, 1, q.Recovered())
+
+	// the queue keeps working across laps
+	for i := 0; i < 10; i++ {
+		require.NoError(t, q.TrySend([]byte{byte(i)}))
+		got, err := q.TryReceive()
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(i)}, got)
+	}
+	_, err = q.TryReceive()
+	require.ErrorIs(t, err, ErrSharedQueueEmpty)
+}
+
+func TestSharedQueue_RecoveryCrash(t *testing.T) {
+	q, err := CreateSharedQueue(IPC_PRIVATE, IPC_RW, SharedQueueConfig{Slots: 4, SlotSize: 8})
+	require.NoError(t, err)
+	defer q.Remove()
+
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	dead := uint64(cmd.Process.Pid)
+
+	for _, owner := range []func(pos uint64) uint64{
+		// a consumer died while tombstoning the slot of a dead producer
+		func(pos uint64) uint64 { return sharedRecovering | dead<<32 | ownerTag(pos) },
+		// the same, as left behind by a consumer that did not record its pid
+		func(pos uint64) uint64 { return ownerTag(pos) },
+	} {
+		pos := q.enq.Load()
+		s, _ := q.slot(pos)
+		s.owner.Store(owner(pos))
+
+		require.NoError(t, q.TrySend([]byte("after")))
+		done := make(chan error, 1)
+		go func() {
+			got, err := q.TryReceive()
+			if err == nil && string(got) != "after" {
+				err = fmt.Errorf("got %q", got)
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("TryReceive spins on the abandoned slot")
+		}
+	}
+	require.EqualValues(t, 2, q.Recovered())
+
+	// a live recovering consumer is waited for rather than taken over
+	pos := q.enq.Load()
+	s, _ := q.slot(pos)
+	s.owner.Store(sharedRecovering | uint64(os.Getpid())<<32 | ownerTag(pos))
+	require.True(t, q.recover(pos, s))
+	require.EqualValues(t, 2, q.Recovered())
+}
+
+func TestSharedQueue_PublishAfterRecovery(t *testing.T) {
+	q, err := CreateSharedQueue(IPC_PRIVATE, IPC_RW, SharedQueueConfig{Slots: 4, SlotSize: 8})
+	require.NoError(t, err)
+	defer q.Remove()
+
+	// a producer claimed the slot, then a consumer wrongly took it for dead and tombstoned it
+	pos := q.enq.Load()
+	s, payload := q.slot(pos)
+	claim := uint64(os.Getpid())<<32 | ownerTag(pos)
+	s.owner.Store(sharedRecovering | 1<<32 | ownerTag(pos))
+	s.length.Store(sharedTombstone)
+	q.setSeq(s, pos, pos+1)
+
+	// the producer backs off instead of writing into the slot
+	require.False(t, q.publish(s, pos, claim, payload, []byte("late")))
+	require.Equal(t, pos+1, q.seq(s, pos))
+	require.Equal(t, uint32(sharedTombstone), s.length.Load())
+}
+
+func TestSharedQueue_ForeignNamespace(t *testing.T) {
+	q, err := CreateSharedQueue(IPC_PRIVATE, IPC_RW, SharedQueueConfig{Slots: 4, SlotSize: 8})
+	require.NoError(t, err)
+	defer q.Remove()
+	require.False(t, q.foreign)
+
+	// a handle opened from another pid namespace
+	ns := (*atomic.Uint64)(unsafe.Pointer(&q.mem[40]))
+	local := ns.Load()
+	ns.Store(local + 1)
+	seg, err := NewShm().Attach(q.ID(), 0)
+	require.NoError(t, err)
+	other, err := newSharedQueue(seg)
+	require.NoError(t, err)
+	defer other.Close()
+	ns.Store(local)
+	require.True(t, other.foreign)
+
+	require.NoError(t, other.TrySend([]byte("x")))
+	s, _ := q.slot(0)
+	require.NotZero(t, s.owner.Load()&sharedForeign)
+	got, err := q.TryReceive()
+	require.NoError(t, err)
+	require.Equal(t, "x", string(got))
+
+	cmd := exec.Command("true")
+	require.NoError(t, cmd.Run())
+	dead := uint64(cmd.Process.Pid)
+
+	// the pid of a foreign producer is not checked here
+	pos := q.enq.Load()
+	s, _ = q.slot(pos)
+	s.owner.Store(sharedForeign | dead<<32 | ownerTag(pos))
+	q.enq.Store(pos + 1)
+	_, err = q.TryReceive()
+	require.ErrorIs(t, err, ErrSharedQueueEmpty)
+
+	// nor is the pid of a local producer from the foreign side
+	s.owner.Store(dead<<32 | ownerTag(pos))
+	_, err = other.TryReceive()
+	require.ErrorIs(t, err, ErrSharedQueueEmpty)
+	require.Zero(t, q.Recovered())
+
+	// the local side recovers it
+	_, err = q.TryReceive()
+	require.ErrorIs(t, err, ErrSharedQueueEmpty)
+	require.EqualValues(t, 1, q.Recovered())
+}
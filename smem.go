@@ -3,10 +3,9 @@ package ipc
 import (
 	"encoding/binary"
 	"fmt"
-	"reflect"
-	"runtime"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -57,6 +56,7 @@ const (
 type ShmInfo struct {
 	sync.RWMutex
 	id2Size   map[int]uint64            // {id -> size}
+	id2Key    map[int]uint64            // {id -> key}
 	addr2Size map[unsafe.Pointer]uint64 // {addr -> size}
 	addr2Id   map[unsafe.Pointer]int    // { addr -> id }
 }
@@ -64,6 +64,7 @@ type ShmInfo struct {
 func NewShm() *ShmInfo {
 	return &ShmInfo{
 		id2Size:   make(map[int]uint64, 64),
+		id2Key:    make(map[int]uint64, 64),
 		addr2Size: make(map[unsafe.Pointer]uint64, 64),
 		addr2Id:   make(map[unsafe.Pointer]int, 64),
 	}
@@ -92,6 +93,7 @@ func (s *ShmInfo) Shmget(key, size uint64, shmflg int) (int, error) {
 	sid := int(_sid)
 	s.Lock()
 	s.id2Size[sid] = size
+	s.id2Key[sid] = key
 	s.Unlock()
 	return sid, nil
 }
@@ -106,9 +108,17 @@ func (s *ShmInfo) Shmat(id int, shmflg int) (unsafe.Pointer, error) {
 	if err != 0 {
 		return nil, err
 	}
-	addr := unsafe.Pointer(_addr)
+	addr := *(*unsafe.Pointer)(unsafe.Pointer(&_addr))
+
+	// the kernel knows the real size, also of segments created by other processes
+	ds := &ShmidDs{}
+	statErr := s.Shmctl(id, IPC_STAT, ds)
 
 	s.Lock()
+	if statErr == nil {
+		s.id2Size[id] = ds.Segsz
+		s.id2Key[id] = uint64(uint32(ds.Perm.Key))
+	}
 	if size, ok := s.id2Size[id]; ok {
 		s.addr2Size[addr] = size
 		s.addr2Id[addr] = id
@@ -138,16 +148,23 @@ func (s *ShmInfo) Shmdt(addr unsafe.Pointer) error {
 // IPC_STAT: Retrieve the status of shared memory and copy the shmid_ds structure of the shared memory into the buffer, buf.
 // IPC_SET: Change the status of the shared memory and copy the uid, gid, and mode in the shmid_ds structure pointed to by buf to the shmid_ds structure of the shared memory.
 // IPC_RMID: Delete this shared memory
-// buf: Shared memory management structure. For specific instructions, please refer to the shared memory kernel structure definition section.
-func (s *ShmInfo) Shmctl(smid, cmd int) error {
-	var buf uintptr = 0
-	_, _, err := syscall.Syscall(syscall.SYS_SHMCTL, uintptr(smid), uintptr(cmd), buf)
-	if err != 0 || cmd != IPC_RMID {
+// buf: Shared memory management structure, see ShmidDs. Only the first one is used and only IPC_STAT and IPC_SET need it.
+func (s *ShmInfo) Shmctl(smid, cmd int, buf ...*ShmidDs) error {
+	var ds *ShmidDs
+	if len(buf) > 0 {
+		ds = buf[0]
+	}
+	_, _, err := syscall.Syscall(syscall.SYS_SHMCTL, uintptr(smid), uintptr(cmd), uintptr(unsafe.Pointer(ds)))
+	if err != 0 {
 		return err
 	}
+	if cmd != IPC_RMID {
+		return nil
+	}
 
 	s.Lock()
 	delete(s.id2Size, smid)
+	delete(s.id2Key, smid)
 	for addr, id := range s.addr2Id {
 		if id == smid {
 			delete(s.addr2Id, addr)
@@ -158,24 +175,62 @@ func (s *ShmInfo) Shmctl(smid, cmd int) error {
 	return nil
 }
 
-// Shmread ... Read data from the shared memory
+// Stat ... Returns the kernel's view of the segment: size, creator and last attaching pid,
+// number of attachments and timestamps
+func (s *ShmInfo) Stat(smid int) (*ShmidDs, error) {
+	ds := &ShmidDs{}
+	if err := s.Shmctl(smid, IPC_STAT, ds); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// ShmidDs ... Mirrors the kernel shmid64_ds structure used by shmctl
+type ShmidDs struct {
+	Perm   IpcPerm
+	Segsz  uint64 // size of the segment in bytes
+	Atime  int64  // time of last shmat
+	Dtime  int64  // time of last shmdt
+	Ctime  int64  // time of last change
+	Cpid   int32  // pid of the creator
+	Lpid   int32  // pid of last shmat/shmdt
+	Nattch uint64 // number of current attaches
+	_      [2]uint64
+}
+
+// AttachTime ... Returns the time of the last shmat, zero if never attached
+func (ds *ShmidDs) AttachTime() time.Time {
+	return unixTime(ds.Atime)
+}
+
+// DetachTime ... Returns the time of the last shmdt, zero if never detached
+func (ds *ShmidDs) DetachTime() time.Time {
+	return unixTime(ds.Dtime)
+}
+
+// ChangeTime ... Returns the time of the last change by shmget or IPC_SET
+func (ds *ShmidDs) ChangeTime() time.Time {
+	return unixTime(ds.Ctime)
+}
+
+// Shmread ... Read data from the shared memory.
+// Returns nil if the length prefix points past the end of an attached segment
 func (s *ShmInfo) Shmread(addr unsafe.Pointer) []byte {
-	ptr := (*[4]byte)(addr)
-	if ptr == nil {
+	if addr == nil {
 		return nil
 	}
-	sizeBytes := *ptr
-	size := int(binary.BigEndian.Uint32(sizeBytes[:])) - 4
+	size := int(binary.BigEndian.Uint32(unsafe.Slice((*byte)(addr), 4))) - 4
 	if size <= 0 {
 		return []byte{}
 	}
+	s.RLock()
+	maxSize, ok := s.addr2Size[addr]
+	s.RUnlock()
+	if ok && uint64(size)+4 > maxSize {
+		return nil
+	}
 	buf := make([]byte, size)
-	copy(buf, *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
-		Data: uintptr(addr) + 4,
-		Len:  size,
-		Cap:  size,
-	})))
-	runtime.KeepAlive(addr)
+	copy(buf, unsafe.Slice((*byte)(unsafe.Add(addr, 4)), size))
 	return buf
 }
 
@@ -188,15 +243,9 @@ func (s *ShmInfo) Shmwrite(addr unsafe.Pointer, data []byte) error {
 	if uint64(size) > maxSize {
 		return fmt.Errorf("not enough space, (4 + %d) > %d", len(data), maxSize)
 	}
-	buf := make([]byte, size)
-	// write the size into the first 4 bytes of buf in BigEndian format
-	binary.BigEndian.PutUint32(buf, uint32(size))
-	copy(buf[4:], data)
-	// convert the address of the `buf` slice into a pointer.
-	ptr := unsafe.Pointer(*(*uintptr)(unsafe.Pointer(&buf)))
-	tmp := reflect.ArrayOf(size, reflect.TypeOf(byte(0)))
-	// create a type `tmp` at memory address `addr`
-	// and sets the value at that address to the value pointed to by `ptr`
-	reflect.NewAt(tmp, addr).Elem().Set(reflect.NewAt(tmp, ptr).Elem())
+	dst := unsafe.Slice((*byte)(addr), size)
+	// write the size into the first 4 bytes in BigEndian format
+	binary.BigEndian.PutUint32(dst, uint32(size))
+	copy(dst[4:], data)
 	return nil
 }
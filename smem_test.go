@@ -1,9 +1,13 @@
 package ipc
 
 import (
+	"encoding/binary"
 	"github.com/stretchr/testify/require"
+	"os"
+	"syscall"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func TestSharedMem_Write(t *testing.T) {
@@ -13,7 +17,7 @@ func TestSharedMem_Write(t *testing.T) {
 	shmid, err := s.Shmget(key, 32, IPC_CREAT|IPC_RW)
 	require.NoError(t, err)
 	// attach action
-	shmaddr, err := s.Shmat(shmid, SHM_REMAP)
+	shmaddr, err := s.Shmat(shmid, 0)
 	require.NoError(t, err)
 	// write action
 	s.Shmwrite(shmaddr, []byte("test"))
@@ -38,7 +42,7 @@ func TestSharedMem_Read(t *testing.T) {
 		}
 	}()
 
-	shmaddr, err := s.Shmat(shmid, SHM_REMAP)
+	shmaddr, err := s.Shmat(shmid, 0)
 	require.NoError(t, err)
 
 	data := s.Shmread(shmaddr)
@@ -56,7 +60,7 @@ func TestSharedMem_Shmat(t *testing.T) {
 			panic(err)
 		}
 		shmid, err := s.Shmget(key, 32, IPC_CREAT|IPC_RW)
-		shmaddr, err := s.Shmat(shmid, SHM_REMAP)
+		shmaddr, err := s.Shmat(shmid, 0)
 		if err != nil {
 			t.Error(err)
 		}
@@ -73,7 +77,7 @@ func TestSharedMem_Shmat(t *testing.T) {
 
 	shmid, err := s.Shmget(key, 0, IPC_R)
 	require.NoError(t, err)
-	shmaddr, err := s.Shmat(shmid, SHM_REMAP)
+	shmaddr, err := s.Shmat(shmid, 0)
 	require.NoError(t, err)
 
 	got := s.Shmread(shmaddr)
@@ -84,3 +88,51 @@ func TestSharedMem_Shmat(t *testing.T) {
 
 	<-done
 }
+
+func TestSharedMem_Stat(t *testing.T) {
+	s := NewShm()
+	shmid, err := s.Shmget(IPC_PRIVATE, 100, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer s.Shmctl(shmid, IPC_RMID)
+
+	// a consumer that did not create the segment can write to it
+	consumer := NewShm()
+	shmaddr, err := consumer.Shmat(shmid, 0)
+	require.NoError(t, err)
+	defer consumer.Shmdt(shmaddr)
+	require.NoError(t, consumer.Shmwrite(shmaddr, []byte("test")))
+	require.Error(t, consumer.Shmwrite(shmaddr, make([]byte, 100)))
+
+	ds, err := consumer.Stat(shmid)
+	require.NoError(t, err)
+	require.EqualValues(t, 100, ds.Segsz)
+	require.EqualValues(t, os.Getpid(), ds.Cpid)
+	require.EqualValues(t, os.Getpid(), ds.Lpid)
+	require.EqualValues(t, 1, ds.Nattch)
+	require.EqualValues(t, IPC_RW, ds.Perm.Mode&0777)
+	require.WithinDuration(t, time.Now(), ds.AttachTime(), time.Minute)
+	require.True(t, ds.DetachTime().IsZero())
+
+	ds.Perm.Mode = IPC_R
+	require.NoError(t, s.Shmctl(shmid, IPC_SET, ds))
+	ds, err = s.Stat(shmid)
+	require.NoError(t, err)
+	require.EqualValues(t, IPC_R, ds.Perm.Mode&0777)
+
+	require.ErrorIs(t, s.Shmctl(shmid, IPC_STAT), syscall.EFAULT)
+}
+
+func TestSharedMem_ReadBounds(t *testing.T) {
+	s := NewShm()
+	shmid, err := s.Shmget(IPC_PRIVATE, 64, IPC_CREAT|IPC_RW)
+	require.NoError(t, err)
+	defer s.Shmctl(shmid, IPC_RMID)
+	shmaddr, err := s.Shmat(shmid, 0)
+	require.NoError(t, err)
+	defer s.Shmdt(shmaddr)
+
+	// a length prefix written by something else
+	seg := unsafe.Slice((*byte)(shmaddr), 4)
+	binary.BigEndian.PutUint32(seg, 1<<20)
+	require.Nil(t, s.Shmread(shmaddr))
+}